@@ -0,0 +1,17 @@
+resource "dockermachine_certificates" "team" {
+    directory    = "${path.module}/certs"
+    organization = "team"
+}
+
+resource "dockermachine_virtualbox" "node" {
+    count = 2
+    name = "${format("node-%02d", count.index+1)}"
+    tls_ca_cert     = "${dockermachine_certificates.team.ca_cert}"
+    tls_ca_key      = "${dockermachine_certificates.team.ca_key}"
+    tls_client_cert = "${dockermachine_certificates.team.client_cert}"
+    tls_client_key  = "${dockermachine_certificates.team.client_key}"
+
+    # Issue new server certificates, signed by the new CA, whenever the
+    # certificates are rotated.
+    regenerate_certs = "${dockermachine_certificates.team.ca_cert_fingerprint}"
+}
@@ -0,0 +1,160 @@
+package provider
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// encryptedMagic starts the data encrypted by the provider, followed by the
+// salt the key was derived with, and the nonce.
+var encryptedMagic = []byte("DMENC2\n")
+
+// legacyEncryptedMagic starts the data encrypted by former versions of the
+// provider, under the SHA-256 digest of the passphrase. Such data is still
+// decrypted, and encrypted again with a derived key when saved.
+var legacyEncryptedMagic = []byte("DMENC1\n")
+
+const (
+	saltSize = 16
+	// kdfIterations is the PBKDF2 work factor, deriving a key in tens of
+	// milliseconds.
+	kdfIterations = 100000
+)
+
+// passphraseCipher encrypts data with AES-256-GCM, under keys derived from a
+// passphrase with PBKDF2-HMAC-SHA256 and a random salt, which is stored with
+// the encrypted data. Derived keys are cached, since deriving them is slow on
+// purpose: the data encrypted by a passphraseCipher all shares its salt.
+type passphraseCipher struct {
+	passphrase []byte
+	salt       []byte
+	aeads      map[string]cipher.AEAD
+	mutex      sync.Mutex
+}
+
+func newPassphraseCipher(passphrase string) (*passphraseCipher, error) {
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	return &passphraseCipher{
+		passphrase: []byte(passphrase),
+		salt:       salt,
+		aeads:      make(map[string]cipher.AEAD),
+	}, nil
+}
+
+// aead returns the cipher keyed with the key derived with salt.
+func (c *passphraseCipher) aead(salt []byte) (cipher.AEAD, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if aead, ok := c.aeads[string(salt)]; ok {
+		return aead, nil
+	}
+	aead, err := newAEAD(pbkdf2SHA256(c.passphrase, salt, kdfIterations, 32))
+	if err != nil {
+		return nil, err
+	}
+	c.aeads[string(salt)] = aead
+	return aead, nil
+}
+
+// encrypt seals plaintext, prefixing the result with encryptedMagic, the salt
+// and the random nonce used.
+func (c *passphraseCipher) encrypt(plaintext []byte) ([]byte, error) {
+	aead, err := c.aead(c.salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	header := append(append([]byte{}, encryptedMagic...), c.salt...)
+	out := append(append([]byte{}, header...), nonce...)
+	return aead.Seal(out, nonce, plaintext, header), nil
+}
+
+// decrypt opens data sealed by encrypt, or by former versions of the
+// provider.
+func (c *passphraseCipher) decrypt(data []byte) ([]byte, error) {
+	var aead cipher.AEAD
+	var header []byte
+	var err error
+	switch {
+	case bytes.HasPrefix(data, encryptedMagic):
+		if len(data) < len(encryptedMagic)+saltSize {
+			return nil, fmt.Errorf("encrypted data is truncated")
+		}
+		header = data[:len(encryptedMagic)+saltSize]
+		aead, err = c.aead(header[len(encryptedMagic):])
+	case bytes.HasPrefix(data, legacyEncryptedMagic):
+		header = legacyEncryptedMagic
+		key := sha256.Sum256(c.passphrase)
+		aead, err = newAEAD(key[:])
+	default:
+		return nil, fmt.Errorf("data is not encrypted")
+	}
+	if err != nil {
+		return nil, err
+	}
+	data = data[len(header):]
+	if len(data) < aead.NonceSize() {
+		return nil, fmt.Errorf("encrypted data is truncated")
+	}
+	plaintext, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], header)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting data, check the encryption key: %s", err)
+	}
+	return plaintext, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func isEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, encryptedMagic) || isLegacyEncrypted(data)
+}
+
+func isLegacyEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, legacyEncryptedMagic)
+}
+
+// pbkdf2SHA256 derives a key of keyLen bytes from password and salt, as
+// specified by PBKDF2 (RFC 8018) with HMAC-SHA256 as pseudorandom function.
+func pbkdf2SHA256(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	var key []byte
+	for block := uint32(1); len(key) < keyLen; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		var index [4]byte
+		binary.BigEndian.PutUint32(index[:], block)
+		prf.Write(index[:])
+		u := prf.Sum(nil)
+		t := append([]byte{}, u...)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
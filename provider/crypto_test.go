@@ -0,0 +1,83 @@
+package provider
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"testing"
+)
+
+// Test vectors of PBKDF2-HMAC-SHA256, as published along with RFC 7914.
+var pbkdf2Tests = []struct {
+	password   string
+	salt       string
+	iterations int
+	key        string
+}{
+	{"password", "salt", 1, "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b"},
+	{"password", "salt", 2, "ae4d0c95af6b46d32d0adff928f06dd02a303f8ef3c251dfd6e2d85a95474c43"},
+	{"password", "salt", 4096, "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a"},
+}
+
+func TestPBKDF2SHA256(t *testing.T) {
+	for _, test := range pbkdf2Tests {
+		key := hex.EncodeToString(pbkdf2SHA256([]byte(test.password), []byte(test.salt), test.iterations, 32))
+		if key != test.key {
+			t.Errorf("%d iterations: expected %s, got %s", test.iterations, test.key, key)
+		}
+	}
+}
+
+func TestPassphraseCipher(t *testing.T) {
+	c, err := newPassphraseCipher("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := c.encrypt([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isEncrypted(data) || isLegacyEncrypted(data) {
+		t.Fatalf("expected data encrypted with a derived key, got %q", data)
+	}
+
+	// Another cipher, e.g. of another process, has its own salt.
+	other, err := newPassphraseCipher("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plaintext, err := other.decrypt(data); err != nil || string(plaintext) != "key" {
+		t.Errorf("expected %q, got %q (%v)", "key", plaintext, err)
+	}
+
+	wrong, err := newPassphraseCipher("wrong")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wrong.decrypt(data); err == nil {
+		t.Error("expected decryption with the wrong passphrase to fail")
+	}
+}
+
+func TestPassphraseCipherLegacy(t *testing.T) {
+	key := sha256.Sum256([]byte("secret"))
+	aead, err := newAEAD(key[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		t.Fatal(err)
+	}
+	data := append(append([]byte{}, legacyEncryptedMagic...), nonce...)
+	data = aead.Seal(data, nonce, []byte("key"), legacyEncryptedMagic)
+
+	c, err := newPassphraseCipher("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plaintext, err := c.decrypt(data); err != nil || string(plaintext) != "key" {
+		t.Errorf("expected %q, got %q (%v)", "key", plaintext, err)
+	}
+}
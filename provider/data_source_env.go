@@ -0,0 +1,199 @@
+package provider
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/machine/libmachine/auth"
+	"github.com/docker/machine/libmachine/check"
+	"github.com/docker/machine/libmachine/mcnerror"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/helper/validation"
+)
+
+// envFormats maps each shell to the format of a variable assignment, as
+// printed by `docker-machine env`.
+var envFormats = map[string]string{
+	"bash":       "export %s=\"%s\"\n",
+	"fish":       "set -gx %s \"%s\";\n",
+	"powershell": "$Env:%s = \"%s\"\n",
+	"cmd":        "SET %s=%s\n",
+	"emacs":      "(setenv \"%s\" \"%s\")\n",
+	"tcsh":       "setenv %s \"%s\";\n",
+}
+
+func dataSourceEnv() *schema.Resource {
+	return &schema.Resource{
+		Read: dataSourceEnvRead,
+		Schema: map[string]*schema.Schema{
+			"name": {
+				Type:     schema.TypeString,
+				Required: true,
+			},
+			"shell": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      "bash",
+				ValidateFunc: validation.StringInSlice([]string{"bash", "fish", "powershell", "cmd", "emacs", "tcsh"}, false),
+			},
+			"swarm": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+			"no_proxy": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+			"cert_path": {
+				Type:     schema.TypeString,
+				Optional: true,
+				Computed: true,
+			},
+			"environment": {
+				Type:     schema.TypeMap,
+				Computed: true,
+			},
+			"script": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+		},
+	}
+}
+
+func dataSourceEnvRead(d *schema.ResourceData, meta interface{}) error {
+	config := meta.(*providerConfig)
+	name := d.Get("name").(string)
+	client, closeStore, err := openBackendStore(config, config.backend, name, true, nil)
+	if err != nil {
+		return err
+	}
+	defer closeStore(nil)
+	exists, err := client.Exists(name)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return mcnerror.ErrHostDoesNotExist{
+			Name: name,
+		}
+	}
+	h, err := client.Load(name)
+	if err != nil {
+		return err
+	}
+
+	// The certificates of a store backend are in a temporary store, removed
+	// once the data source is read, and the client key of an encrypted
+	// store is only decrypted in the meantime: copy them to a lasting
+	// directory. The copied key is in plaintext, so an encrypted store
+	// requires the directory to be chosen explicitly.
+	certPath := d.Get("cert_path").(string)
+	if certPath == "" && config.encryption != nil {
+		return fmt.Errorf("Error reading environment of machine %q: cert_path must be set when the store is encrypted, since the client key is copied there in plaintext", name)
+	}
+	if certPath == "" && config.backend != nil {
+		certPath = filepath.Join(config.client.Path, "env", name)
+	}
+	var dockerHost string
+	err = client.withKeys(h, func() error {
+		var authOptions *auth.Options
+		var err error
+		dockerHost, authOptions, err = check.DefaultConnChecker.Check(h, d.Get("swarm").(bool))
+		if err != nil {
+			return fmt.Errorf("Error checking TLS connection: %s", err)
+		}
+		if certPath == "" {
+			certPath = authOptions.CertDir
+		} else if err := copyClientCerts(authOptions, certPath); err != nil {
+			return fmt.Errorf("Error copying certificates to %s: %s", certPath, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Keep the order of `docker-machine env` in the script.
+	keys := []string{"DOCKER_TLS_VERIFY", "DOCKER_HOST", "DOCKER_CERT_PATH", "DOCKER_MACHINE_NAME"}
+	env := map[string]string{
+		"DOCKER_TLS_VERIFY":   "1",
+		"DOCKER_HOST":         dockerHost,
+		"DOCKER_CERT_PATH":    certPath,
+		"DOCKER_MACHINE_NAME": name,
+	}
+
+	if d.Get("no_proxy").(bool) {
+		address, err := h.Driver.GetIP()
+		if err != nil {
+			return fmt.Errorf("Error attempting to retrieve address: %s", err)
+		}
+		key, value := noProxy(address)
+		keys = append(keys, key)
+		env[key] = value
+	}
+
+	var script bytes.Buffer
+	format := envFormats[d.Get("shell").(string)]
+	for _, key := range keys {
+		fmt.Fprintf(&script, format, key, env[key])
+	}
+
+	d.Set("cert_path", certPath)
+	d.Set("environment", env)
+	d.Set("script", script.String())
+
+	d.SetId(name)
+
+	return nil
+}
+
+// copyClientCerts copies the CA certificate and the client certificate and key
+// of authOptions into dir, under the names the docker client expects.
+func copyClientCerts(authOptions *auth.Options, dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	files := map[string]string{
+		"ca.pem":   authOptions.CaCertPath,
+		"cert.pem": authOptions.ClientCertPath,
+		"key.pem":  authOptions.ClientKeyPath,
+	}
+	for name, source := range files {
+		data, err := ioutil.ReadFile(source)
+		if err != nil {
+			return err
+		}
+		if err := replaceFile(filepath.Join(dir, name), data, 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// noProxy returns the proxy exclusion variable found in the environment, or
+// NO_PROXY if there is none, with the machine address appended to its value.
+func noProxy(address string) (string, string) {
+	key, value := "NO_PROXY", os.Getenv("NO_PROXY")
+	if value == "" {
+		if lower := os.Getenv("no_proxy"); lower != "" {
+			key, value = "no_proxy", lower
+		}
+	}
+	for _, host := range strings.Split(value, ",") {
+		if host == address {
+			return key, value
+		}
+	}
+	if value == "" {
+		return key, address
+	}
+	return key, value + "," + address
+}
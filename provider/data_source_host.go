@@ -0,0 +1,260 @@
+package provider
+
+import (
+	"github.com/docker/machine/libmachine/host"
+	"github.com/docker/machine/libmachine/mcnerror"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+func dataSourceHost() *schema.Resource {
+	return &schema.Resource{
+		Read: dataSourceHostRead,
+		Schema: map[string]*schema.Schema{
+			"name": {
+				Type:     schema.TypeString,
+				Required: true,
+			},
+			"driver": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"state": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"address": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"docker_url": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"docker_version": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"ssh_hostname": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"ssh_port": {
+				Type:     schema.TypeInt,
+				Computed: true,
+			},
+			"ssh_username": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"ssh_keypath": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"storage_path": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"certs_directory": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"tls_ca_cert": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"tls_ca_key": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"tls_client_cert": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"tls_client_key": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"tls_server_cert": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"tls_server_key": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"tls_san": {
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+			"engine_opt": {
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+			"engine_env": {
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+			"engine_insecure_registry": {
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+			"engine_label": {
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+			"engine_registry_mirror": {
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+			"engine_storage_driver": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"engine_install_url": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"swarm": {
+				Type:     schema.TypeBool,
+				Computed: true,
+			},
+			"swarm_master": {
+				Type:     schema.TypeBool,
+				Computed: true,
+			},
+			"swarm_image": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"swarm_discovery": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"swarm_addr": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"swarm_host": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"swarm_strategy": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"swarm_opt": {
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+			"swarm_join_opt": {
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+			"swarm_experimental": {
+				Type:     schema.TypeBool,
+				Computed: true,
+			},
+		},
+	}
+}
+
+func dataSourceHostRead(d *schema.ResourceData, meta interface{}) error {
+	config := meta.(*providerConfig)
+	name := d.Get("name").(string)
+	client, closeStore, err := openBackendStore(config, config.backend, name, true, nil)
+	if err != nil {
+		return err
+	}
+	defer closeStore(nil)
+	exists, err := client.Exists(name)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return mcnerror.ErrHostDoesNotExist{
+			Name: name,
+		}
+	}
+	h, err := client.Load(name)
+	if err != nil {
+		return err
+	}
+
+	d.Set("driver", h.DriverName)
+	d.Set("ssh_username", h.Driver.GetSSHUsername())
+	d.Set("ssh_keypath", h.Driver.GetSSHKeyPath())
+	if h.HostOptions != nil {
+		if authOptions := h.HostOptions.AuthOptions; authOptions != nil {
+			d.Set("storage_path", authOptions.StorePath)
+			d.Set("certs_directory", authOptions.CertDir)
+			d.Set("tls_ca_cert", authOptions.CaCertPath)
+			d.Set("tls_ca_key", authOptions.CaPrivateKeyPath)
+			d.Set("tls_client_cert", authOptions.ClientCertPath)
+			d.Set("tls_client_key", authOptions.ClientKeyPath)
+			d.Set("tls_server_cert", authOptions.ServerCertPath)
+			d.Set("tls_server_key", authOptions.ServerKeyPath)
+		}
+		setHostOptions(d, h.HostOptions)
+	}
+	if err := readMachine(d, h); err != nil {
+		return err
+	}
+
+	d.SetId(name)
+
+	return nil
+}
+
+// setHostOptions sets the tls_san, engine_* and swarm_* attributes from the
+// options the host was created with.
+func setHostOptions(d *schema.ResourceData, options *host.Options) {
+	if authOptions := options.AuthOptions; authOptions != nil {
+		d.Set("tls_san", authOptions.ServerCertSANs)
+	}
+	if engineOptions := options.EngineOptions; engineOptions != nil {
+		d.Set("engine_opt", engineOptions.ArbitraryFlags)
+		d.Set("engine_env", engineOptions.Env)
+		d.Set("engine_insecure_registry", engineOptions.InsecureRegistry)
+		d.Set("engine_label", engineOptions.Labels)
+		d.Set("engine_registry_mirror", engineOptions.RegistryMirror)
+		d.Set("engine_storage_driver", engineOptions.StorageDriver)
+		d.Set("engine_install_url", engineOptions.InstallURL)
+	}
+	if swarmOptions := options.SwarmOptions; swarmOptions != nil {
+		d.Set("swarm", swarmOptions.Agent)
+		d.Set("swarm_master", swarmOptions.Master)
+		d.Set("swarm_image", swarmOptions.Image)
+		d.Set("swarm_discovery", swarmOptions.Discovery)
+		d.Set("swarm_addr", swarmOptions.Address)
+		d.Set("swarm_host", swarmOptions.Host)
+		d.Set("swarm_strategy", swarmOptions.Strategy)
+		d.Set("swarm_opt", swarmOptions.ArbitraryFlags)
+		d.Set("swarm_join_opt", swarmOptions.ArbitraryJoinFlags)
+		d.Set("swarm_experimental", swarmOptions.IsExperimental)
+	}
+}
@@ -0,0 +1,194 @@
+package provider
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/docker/machine/libmachine/host"
+	"github.com/docker/machine/libmachine/state"
+
+	"github.com/hashicorp/terraform/helper/hashcode"
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/helper/validation"
+)
+
+func dataSourceHosts() *schema.Resource {
+	return &schema.Resource{
+		Read: dataSourceHostsRead,
+		Schema: map[string]*schema.Schema{
+			"driver": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"state": {
+				Type:     schema.TypeString,
+				Optional: true,
+				ValidateFunc: validation.StringInSlice([]string{
+					"running", "paused", "saved", "stopped", "stopping", "starting", "error", "timeout",
+				}, false),
+			},
+			"engine_label": {
+				Type:     schema.TypeList,
+				Optional: true,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+			"name_regex": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ValidateFunc: validation.ValidateRegexp,
+			},
+			"names": {
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+			"hosts": {
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"name": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"driver": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"state": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"url": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"address": {
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func dataSourceHostsRead(d *schema.ResourceData, meta interface{}) error {
+	config := meta.(*providerConfig)
+
+	var nameRegexp *regexp.Regexp
+	if v, ok := d.GetOk("name_regex"); ok {
+		nameRegexp = regexp.MustCompile(v.(string))
+	}
+
+	var hostNames []string
+	var err error
+	if config.backend != nil {
+		hostNames, err = config.backend.list()
+	} else {
+		hostNames, err = config.client.List()
+	}
+	if err != nil {
+		return fmt.Errorf("Error listing machines: %s", err)
+	}
+
+	var names []string
+	var hosts []map[string]interface{}
+	for _, name := range hostNames {
+		if nameRegexp != nil && !nameRegexp.MatchString(name) {
+			continue
+		}
+		summary, err := hostSummary(d, config, name)
+		if err != nil {
+			return fmt.Errorf("Error reading machine %q: %s", name, err)
+		}
+		if summary != nil {
+			names = append(names, name)
+			hosts = append(hosts, summary)
+		}
+	}
+
+	d.Set("names", names)
+	if err := d.Set("hosts", hosts); err != nil {
+		return fmt.Errorf("Error setting hosts: %s", err)
+	}
+
+	d.SetId(strconv.Itoa(hashcode.String(strings.Join(names, ","))))
+
+	return nil
+}
+
+// hostSummary returns the attributes of the named machine in the hosts list,
+// or nil if it does not match the filters.
+func hostSummary(d *schema.ResourceData, config *providerConfig, name string) (map[string]interface{}, error) {
+	client, closeStore, err := openBackendStore(config, config.backend, name, true, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer closeStore(nil)
+
+	h, err := client.Load(name)
+	if err != nil {
+		return nil, err
+	}
+	if driverName := d.Get("driver").(string); driverName != "" && h.DriverName != driverName {
+		return nil, nil
+	}
+	if labels := is2ss(d.Get("engine_label").([]interface{})); len(labels) > 0 && !hasEngineLabels(h, labels) {
+		return nil, nil
+	}
+
+	machineState, err := h.Driver.GetState()
+	if err != nil {
+		log.Printf("[WARN] Error attempting to retrieve state of machine %q: %s", name, err)
+		machineState = state.Error
+	}
+	hostState := strings.ToLower(machineState.String())
+	if wantedState := d.Get("state").(string); wantedState != "" && hostState != wantedState {
+		return nil, nil
+	}
+
+	var url, address string
+	if machineState == state.Running {
+		if url, err = h.Driver.GetURL(); err != nil {
+			log.Printf("[WARN] Error attempting to retrieve docker url of machine %q: %s", name, err)
+		}
+		if address, err = h.Driver.GetIP(); err != nil {
+			log.Printf("[WARN] Error attempting to retrieve address of machine %q: %s", name, err)
+		}
+	}
+
+	return map[string]interface{}{
+		"name":    name,
+		"driver":  h.DriverName,
+		"state":   hostState,
+		"url":     url,
+		"address": address,
+	}, nil
+}
+
+// hasEngineLabels tells whether all the wanted labels are among the engine
+// labels of a machine.
+func hasEngineLabels(h *host.Host, wanted []string) bool {
+	if h.HostOptions == nil || h.HostOptions.EngineOptions == nil {
+		return false
+	}
+	set := make(map[string]bool)
+	for _, label := range h.HostOptions.EngineOptions.Labels {
+		set[label] = true
+	}
+	for _, label := range wanted {
+		if !set[label] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,122 @@
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/docker/machine/libmachine/drivers/plugin/localbinary"
+	"github.com/docker/machine/libmachine/drivers/rpc"
+	"github.com/docker/machine/libmachine/mcnflag"
+)
+
+const (
+	driverPluginPrefix = "docker-machine-driver-"
+	pluginDirsEnv      = "DOCKERMACHINE_PLUGIN_DIRS"
+)
+
+var pluginNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// addPluginDirs prepends dirs to PATH, which is where libmachine looks for the
+// driver plugin binaries.
+func addPluginDirs(dirs []string) {
+	if len(dirs) == 0 {
+		return
+	}
+	path := append(dirs, filepath.SplitList(os.Getenv("PATH"))...)
+	os.Setenv("PATH", strings.Join(path, string(os.PathListSeparator)))
+}
+
+// discoverDrivers scans PATH for docker-machine-driver-* binaries that are not
+// embedded into the provider, and asks each of them for its create flags.
+// Problems are returned as warnings, so that a broken plugin does not prevent
+// the use of the other drivers.
+func discoverDrivers() (map[string][]mcnflag.Flag, []string) {
+	var warnings []string
+	discovered := make(map[string][]mcnflag.Flag)
+	known := map[string]bool{"external": true, "certificates": true}
+	for _, name := range localbinary.CoreDrivers {
+		known[name] = true
+	}
+
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, file := range files {
+			name, ok := driverPluginName(file)
+			if !ok || known[name] {
+				continue
+			}
+			// As for exec.LookPath, the first binary found in PATH wins.
+			known[name] = true
+			if !pluginNameRegexp.MatchString(name) {
+				warnings = append(warnings, fmt.Sprintf("Ignoring driver plugin %q: invalid driver name", filepath.Join(dir, file.Name())))
+				continue
+			}
+			flags, err := driverCreateFlags(name)
+			if err != nil {
+				warnings = append(warnings, fmt.Sprintf("Ignoring driver plugin %q: %s", filepath.Join(dir, file.Name()), err))
+				continue
+			}
+			log.Printf("[DEBUG] Discovered driver plugin %q in %s", name, dir)
+			discovered[name] = flags
+		}
+	}
+
+	return discovered, warnings
+}
+
+// driverPluginName returns the driver name of a plugin binary, if file is one.
+func driverPluginName(file os.FileInfo) (string, bool) {
+	name := file.Name()
+	if !strings.HasPrefix(name, driverPluginPrefix) || file.IsDir() {
+		return "", false
+	}
+	if runtime.GOOS == "windows" {
+		if !strings.HasSuffix(strings.ToLower(name), ".exe") {
+			return "", false
+		}
+		name = name[:len(name)-len(".exe")]
+	} else if file.Mode()&0111 == 0 {
+		return "", false
+	}
+	return strings.TrimPrefix(name, driverPluginPrefix), true
+}
+
+// driverCreateFlags starts the plugin of the named driver and returns its
+// create flags.
+func driverCreateFlags(driverName string) ([]mcnflag.Flag, error) {
+	data, err := json.Marshal(&drivers.BaseDriver{})
+	if err != nil {
+		return nil, err
+	}
+	factory := rpcdriver.NewRPCClientDriverFactory()
+	defer factory.Close()
+	drv, err := factory.NewRPCClientDriver(driverName, data)
+	if err != nil {
+		return nil, err
+	}
+	return drv.GetCreateFlags(), nil
+}
+
+// pluginDirsFromEnv returns the plugin directories set in the environment.
+// They are needed before the provider is configured, as the resources of the
+// discovered drivers are declared along with the provider schema.
+func pluginDirsFromEnv() []string {
+	var dirs []string
+	for _, dir := range filepath.SplitList(os.Getenv(pluginDirsEnv)) {
+		if dir != "" {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
@@ -0,0 +1,75 @@
+package provider
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// concurrencyLimit bounds the number of machines on which an operation runs at
+// the same time, either globally or per driver.
+type concurrencyLimit struct {
+	operation string
+	limit     int
+	perDriver map[string]int
+	// slots holds a semaphore per driver with its own limit, and the global
+	// one under the empty name.
+	slots map[string]chan struct{}
+	mutex sync.Mutex
+}
+
+// newConcurrencyLimit returns a limit for operation, where a limit of 0 means
+// there is none.
+func newConcurrencyLimit(operation string, limit int, perDriver map[string]interface{}) *concurrencyLimit {
+	l := &concurrencyLimit{
+		operation: operation,
+		limit:     limit,
+		perDriver: make(map[string]int),
+		slots:     make(map[string]chan struct{}),
+	}
+	for driverName, driverLimit := range perDriver {
+		l.perDriver[driverName] = driverLimit.(int)
+	}
+	return l
+}
+
+// acquire waits until the operation can run on a machine of the given driver,
+// and returns the function to call once it is done. The wait is a phase of op
+// of its own, so that an operation timing out in the queue says so, and fails
+// with a timeoutError if the deadline of op passes first.
+func (l *concurrencyLimit) acquire(driverName, name string, op *timedOperation) (func(), error) {
+	slots := l.semaphore(driverName)
+	if slots == nil {
+		return func() {}, nil
+	}
+	op.setPhase(fmt.Sprintf("waiting for a %s slot", l.operation))
+	start := time.Now()
+	timer := time.NewTimer(op.remaining())
+	defer timer.Stop()
+	select {
+	case slots <- struct{}{}:
+	case <-timer.C:
+		return nil, op.timeoutError()
+	}
+	if wait := time.Since(start); wait >= time.Second {
+		log.Printf("[INFO] Waited %s to %s machine %q (%s driver)", wait, l.operation, name, driverName)
+	}
+	return func() { <-slots }, nil
+}
+
+func (l *concurrencyLimit) semaphore(driverName string) chan struct{} {
+	key, limit := "", l.limit
+	if driverLimit, ok := l.perDriver[driverName]; ok {
+		key, limit = driverName, driverLimit
+	}
+	if limit <= 0 {
+		return nil
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.slots[key] == nil {
+		l.slots[key] = make(chan struct{}, limit)
+	}
+	return l.slots[key]
+}
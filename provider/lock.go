@@ -0,0 +1,113 @@
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// certsLockName is the lock taken while the certificates of the store are
+// bootstrapped, so that concurrent creations do not generate distinct CAs.
+const certsLockName = "certs"
+
+// lockHolder describes the process holding a lock, and is written into the
+// lock file.
+type lockHolder struct {
+	PID      int       `json:"pid"`
+	Hostname string    `json:"hostname"`
+	Since    time.Time `json:"since"`
+}
+
+func (h lockHolder) String() string {
+	return fmt.Sprintf("process %d on %s since %s", h.PID, h.Hostname, h.Since.Format(time.RFC3339))
+}
+
+// fileLock is an advisory lock on a file of the locks directory of a store.
+// The lock is released by the operating system if the process dies.
+type fileLock struct {
+	file *os.File
+}
+
+// machineLockName returns the name of the lock of a machine.
+func machineLockName(name string) string {
+	return "machines/" + name
+}
+
+// acquireLock takes the named lock of the store under root, waiting for up to
+// timeout if another process or operation holds it.
+func acquireLock(root, name string, timeout time.Duration) (*fileLock, error) {
+	return acquireLockFile(lockPath(root, name), timeout)
+}
+
+func lockPath(root, name string) string {
+	return filepath.Join(root, "locks", filepath.FromSlash(name)+".lock")
+}
+
+// acquireLockFile locks the file at path, creating it if needed. The holder
+// describes itself in the file, for the error of the processes waiting for it.
+func acquireLockFile(path string, timeout time.Duration) (*fileLock, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("Error creating locks directory: %s", err)
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("Error opening lock %s: %s", path, err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		locked, err := tryLockFile(file)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("Error acquiring lock %s: %s", path, err)
+		}
+		if locked {
+			break
+		}
+		if time.Now().After(deadline) {
+			file.Close()
+			return nil, fmt.Errorf("Error acquiring lock %s: still held by %s after %s", path, readLockHolder(path), timeout)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	hostname, _ := os.Hostname()
+	data, err := json.Marshal(lockHolder{
+		PID:      os.Getpid(),
+		Hostname: hostname,
+		Since:    time.Now(),
+	})
+	if err == nil {
+		if err = file.Truncate(0); err == nil {
+			_, err = file.WriteAt(data, 0)
+		}
+	}
+	if err != nil {
+		unlockFile(file)
+		file.Close()
+		return nil, fmt.Errorf("Error writing lock %s: %s", path, err)
+	}
+	return &fileLock{file: file}, nil
+}
+
+func (l *fileLock) release() error {
+	defer l.file.Close()
+	return unlockFile(l.file)
+}
+
+// readLockHolder describes the holder of the lock file at path, as recorded
+// when it was acquired.
+func readLockHolder(path string) string {
+	file, err := os.Open(path)
+	if err != nil {
+		return "an unknown process"
+	}
+	defer file.Close()
+	var holder lockHolder
+	if err := json.NewDecoder(file).Decode(&holder); err != nil {
+		return "an unknown process"
+	}
+	return holder.String()
+}
@@ -0,0 +1,222 @@
+package provider
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/docker/machine/libmachine/host"
+	"github.com/docker/machine/libmachine/state"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+// setMachineState brings the machine of h from its current state to the one
+// set by the state attribute.
+func setMachineState(d *schema.ResourceData, h *host.Host, current state.State, op *timedOperation) error {
+	desired := d.Get("state").(string)
+	switch desired {
+	case "running":
+		switch current {
+		case state.Paused:
+			return resumeMachine(h, op)
+		case state.Saved, state.Stopped, state.Stopping:
+			if err := op.run("starting the machine", h.Start); err != nil {
+				return fmt.Errorf("Error while attempting to start machine: %s", err)
+			}
+		}
+	case "stopped":
+		switch current {
+		case state.Paused:
+			if err := resumeMachine(h, op); err != nil {
+				return err
+			}
+		case state.Saved:
+			if err := op.run("starting the machine", h.Start); err != nil {
+				return fmt.Errorf("Error while attempting to start machine: %s", err)
+			}
+		case state.Running, state.Starting:
+		default:
+			return nil
+		}
+		return stopHost(d, h, op)
+	case "paused", "saved":
+		if _, ok := driverStateCommands[h.DriverName]; !ok {
+			return fmt.Errorf("Error setting state of machine %q: the %s driver does not support the %s state", h.Name, h.DriverName, desired)
+		}
+		switch current {
+		case state.Paused:
+			if desired == "paused" {
+				return nil
+			}
+		case state.Saved:
+			if desired == "saved" {
+				return nil
+			}
+			if err := op.run("starting the machine", h.Start); err != nil {
+				return fmt.Errorf("Error while attempting to start machine: %s", err)
+			}
+		case state.Stopped, state.Stopping:
+			if err := op.run("starting the machine", h.Start); err != nil {
+				return fmt.Errorf("Error while attempting to start machine: %s", err)
+			}
+		}
+		if desired == "paused" {
+			return runStateCommand(h, "pause", op)
+		}
+		return runStateCommand(h, "save", op)
+	}
+	return nil
+}
+
+// resumeMachine resumes the paused machine of h. Machines of drivers without
+// state commands can only have been paused outside of docker-machine, and are
+// started instead, which their driver may support.
+func resumeMachine(h *host.Host, op *timedOperation) error {
+	if _, ok := driverStateCommands[h.DriverName]; ok {
+		return runStateCommand(h, "resume", op)
+	}
+	if err := op.run("starting the machine", h.Start); err != nil {
+		return fmt.Errorf("Error while attempting to start paused machine: %s", err)
+	}
+	return nil
+}
+
+// stopHost stops the machine of h according to stop_mode: "graceful" waits
+// for the machine to shut down, while "kill" gives it stop_timeout to do so
+// before killing it.
+func stopHost(d *schema.ResourceData, h *host.Host, op *timedOperation) error {
+	if d.Get("stop_mode").(string) != "kill" {
+		if err := op.run("stopping the machine", h.Stop); err != nil {
+			return fmt.Errorf("Error while attempting to stop machine: %s", err)
+		}
+		return nil
+	}
+	timeout, err := time.ParseDuration(d.Get("stop_timeout").(string))
+	if err != nil {
+		return fmt.Errorf("Error parsing stop_timeout: %s", err)
+	}
+	return killHost(h, timeout, op)
+}
+
+// stopPollInterval is the interval between the state checks of a machine
+// given stop_timeout to stop.
+var stopPollInterval = time.Second
+
+// killHost asks the driver to stop the machine of h, and kills it if it is not
+// stopped after timeout. Drivers may block in Stop until the machine is
+// stopped, so the stop call is only reaped once the machine is killed.
+func killHost(h *host.Host, timeout time.Duration, op *timedOperation) error {
+	if remaining := op.remaining(); remaining < timeout {
+		timeout = remaining
+	}
+	if timeout <= 0 {
+		return kill(h, op)
+	}
+	stop := op.start("graceful stop", h.Driver.Stop)
+	var err error
+	if !waitForStop(h, stop, timeout) {
+		log.Printf("[WARN] Machine %q did not stop gracefully within %s, killing it", h.Name, timeout)
+		err = kill(h, op)
+	}
+	if stopErr := op.finish(stop); stopErr != nil {
+		if _, ok := stopErr.(*timeoutError); ok {
+			return stopErr
+		}
+		log.Printf("[DEBUG] Graceful stop of machine %q failed: %s", h.Name, stopErr)
+	}
+	return err
+}
+
+// waitForStop waits for up to timeout for the machine of h to be stopped, and
+// tells whether it is. It gives up early if the stop call fails.
+func waitForStop(h *host.Host, stop *call, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	stopped := drivers.MachineInState(h.Driver, state.Stopped)
+	returned := false
+	for !stopped() {
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return false
+		}
+		if wait > stopPollInterval {
+			wait = stopPollInterval
+		}
+		if returned {
+			time.Sleep(wait)
+		} else if stop.wait(wait) {
+			if stop.err != nil {
+				return false
+			}
+			returned = true
+		}
+	}
+	return true
+}
+
+func kill(h *host.Host, op *timedOperation) error {
+	if err := op.run("killing the machine", h.Kill); err != nil {
+		return fmt.Errorf("Error while attempting to kill machine: %s", err)
+	}
+	return nil
+}
+
+// stateCommands returns the command lines pausing, resuming and saving the
+// state of a machine, for the drivers whose machines support these states.
+// Drivers do not expose these operations, so the hypervisor tools are run.
+type stateCommands func(h *host.Host, action string) []string
+
+var driverStateCommands = map[string]stateCommands{
+	"virtualbox": func(h *host.Host, action string) []string {
+		verb := map[string]string{"pause": "pause", "resume": "resume", "save": "savestate"}[action]
+		return []string{vboxManagePath(), "controlvm", h.Name, verb}
+	},
+	"vmwarefusion": func(h *host.Host, action string) []string {
+		verb := map[string]string{"pause": "pause", "resume": "unpause", "save": "suspend"}[action]
+		vmx := filepath.Join(h.HostOptions.AuthOptions.StorePath, h.Name+".vmx")
+		return []string{lookPath("vmrun", "/Applications/VMware Fusion.app/Contents/Library/vmrun"), "-T", "fusion", verb, vmx}
+	},
+	"hyperv": func(h *host.Host, action string) []string {
+		cmdlet := map[string]string{"pause": "Suspend-VM", "resume": "Resume-VM", "save": "Save-VM"}[action]
+		return []string{"powershell.exe", "-NoProfile", "-NonInteractive", "-Command", cmdlet, "-Name", fmt.Sprintf("'%s'", h.Name)}
+	},
+}
+
+// runStateCommand pauses, resumes or saves the state of the machine of h.
+func runStateCommand(h *host.Host, action string, op *timedOperation) error {
+	commands, ok := driverStateCommands[h.DriverName]
+	if !ok {
+		return fmt.Errorf("Error setting state of machine %q: the %s driver cannot %s machines", h.Name, h.DriverName, action)
+	}
+	args := commands(h, action)
+	return op.run(action+" of the machine", func() error {
+		out, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("Error running %s: %s: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+		}
+		return nil
+	})
+}
+
+// vboxManagePath returns the path of VBoxManage, which the VirtualBox
+// installer does not add to the PATH on Windows.
+func vboxManagePath() string {
+	if dir := os.Getenv("VBOX_MSI_INSTALL_PATH"); dir != "" {
+		return lookPath("VBoxManage", filepath.Join(dir, "VBoxManage.exe"))
+	}
+	return lookPath("VBoxManage", "VBoxManage")
+}
+
+// lookPath returns the path of the named executable in the PATH, or
+// fallback if it is not there.
+func lookPath(name, fallback string) string {
+	if path, err := exec.LookPath(name); err == nil {
+		return path
+	}
+	return fallback
+}
@@ -0,0 +1,107 @@
+package provider
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/docker/machine/libmachine/host"
+	"github.com/docker/machine/libmachine/state"
+)
+
+// stopDriver is a driver whose Stop stops the machine after stopDelay, or
+// hangs until the machine is killed if stopDelay is zero.
+type stopDriver struct {
+	drivers.Driver
+	stopDelay time.Duration
+	stopErr   error
+	state     state.State
+	killed    bool
+	kill      chan struct{}
+	mutex     sync.Mutex
+}
+
+func newStopDriver(stopDelay time.Duration, stopErr error) *stopDriver {
+	return &stopDriver{
+		stopDelay: stopDelay,
+		stopErr:   stopErr,
+		state:     state.Running,
+		kill:      make(chan struct{}),
+	}
+}
+
+func (d *stopDriver) GetState() (state.State, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.state, nil
+}
+
+func (d *stopDriver) Stop() error {
+	if d.stopErr != nil {
+		return d.stopErr
+	}
+	if d.stopDelay == 0 {
+		<-d.kill
+		return errors.New("machine was killed")
+	}
+	time.Sleep(d.stopDelay)
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.state = state.Stopped
+	return nil
+}
+
+func (d *stopDriver) Kill() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.state = state.Stopped
+	d.killed = true
+	close(d.kill)
+	return nil
+}
+
+var killHostTests = []struct {
+	name   string
+	driver *stopDriver
+	killed bool
+}{
+	{
+		name:   "stops in time",
+		driver: newStopDriver(10*time.Millisecond, nil),
+		killed: false,
+	},
+	{
+		name:   "stop hangs",
+		driver: newStopDriver(0, nil),
+		killed: true,
+	},
+	{
+		name:   "stop fails",
+		driver: newStopDriver(0, errors.New("shutdown refused")),
+		killed: true,
+	},
+}
+
+func TestKillHost(t *testing.T) {
+	defer func(interval time.Duration) { stopPollInterval = interval }(stopPollInterval)
+	stopPollInterval = 10 * time.Millisecond
+	for _, test := range killHostTests {
+		h := &host.Host{Name: "test", Driver: test.driver}
+		op := newTimedOperation("stopping machine", 5*time.Second)
+		start := time.Now()
+		if err := killHost(h, 500*time.Millisecond, op); err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("%s: expected the machine to be stopped within stop_timeout, took %s", test.name, elapsed)
+		}
+		if test.driver.killed != test.killed {
+			t.Errorf("%s: expected killed to be %t, got %t", test.name, test.killed, test.driver.killed)
+		}
+		if s, _ := test.driver.GetState(); s != state.Stopped {
+			t.Errorf("%s: expected the machine to be stopped, got %s", test.name, s)
+		}
+	}
+}
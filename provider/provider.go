@@ -2,8 +2,13 @@ package provider
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/helper/validation"
 	"github.com/hashicorp/terraform/terraform"
 
 	"github.com/docker/machine/commands/mcndirs"
@@ -13,37 +18,206 @@ import (
 )
 
 func Provider() terraform.ResourceProvider {
+	var warnings []string
+	pluginDirs := pluginDirsFromEnv()
+	for _, dir := range pluginDirs {
+		if _, err := os.Stat(dir); err != nil {
+			warnings = append(warnings, fmt.Sprintf("Error reading plugin directory: %s", err))
+		}
+	}
+	addPluginDirs(pluginDirs)
+	discovered, discoveryWarnings := discoverDrivers()
+	warnings = append(warnings, discoveryWarnings...)
+
 	resourceMap := make(map[string]*schema.Resource)
+	typedDrivers := make(map[string]bool)
 	for _, str := range localbinary.CoreDrivers {
-		resourceMap[fmt.Sprintf("dockermachine_%s", str)] = resource(str)
-	}
-	//resourceMap["dockermachine_external"] = resourceExternal()
-	return &schema.Provider{
-		ConfigureFunc: providerConfigure,
-		ResourcesMap:  resourceMap,
-		Schema: map[string]*schema.Schema{
-			"debug": {
-				Type:        schema.TypeBool,
-				Optional:    true,
-				Default:     false,
-				Description: "docker-machine debug output",
-			},
-			"storage_path": {
-				Type:        schema.TypeString,
-				Optional:    true,
-				DefaultFunc: storagePathDefault,
-				Description: "docker-machine storage path",
+		resourceMap[fmt.Sprintf("dockermachine_%s", str)] = resource(str, getDriver(str, "", "").GetCreateFlags())
+		typedDrivers[str] = true
+	}
+	for str, flags := range discovered {
+		resourceMap[fmt.Sprintf("dockermachine_%s", strings.Replace(str, "-", "_", -1))] = resource(str, flags)
+		typedDrivers[str] = true
+	}
+	resourceMap["dockermachine_external"] = resourceExternal()
+	resourceMap["dockermachine_certificates"] = resourceCertificates()
+	return &warningProvider{
+		warnings:     warnings,
+		typedDrivers: typedDrivers,
+		Provider: &schema.Provider{
+			ConfigureFunc: providerConfigure,
+			ResourcesMap:  resourceMap,
+			DataSourcesMap: map[string]*schema.Resource{
+				"dockermachine_env":   dataSourceEnv(),
+				"dockermachine_host":  dataSourceHost(),
+				"dockermachine_hosts": dataSourceHosts(),
 			},
-			"certs_directory": {
-				Type:        schema.TypeString,
-				Optional:    true,
-				DefaultFunc: certsDirDefault,
-				Description: "docker-machine certificates directory",
+			Schema: map[string]*schema.Schema{
+				"debug": {
+					Type:        schema.TypeBool,
+					Optional:    true,
+					Default:     false,
+					Description: "docker-machine debug output",
+				},
+				"storage_path": {
+					Type:        schema.TypeString,
+					Optional:    true,
+					DefaultFunc: storagePathDefault,
+					Description: "docker-machine storage path",
+				},
+				"certs_directory": {
+					Type:        schema.TypeString,
+					Optional:    true,
+					DefaultFunc: certsDirDefault,
+					Description: "docker-machine certificates directory",
+				},
+				"store": {
+					Type:          schema.TypeList,
+					Optional:      true,
+					MaxItems:      1,
+					ConflictsWith: []string{"store_in_state"},
+					Description:   "backend keeping the machine stores instead of storage_path",
+					Elem: &schema.Resource{
+						Schema: map[string]*schema.Schema{
+							"backend": {
+								Type:         schema.TypeString,
+								Required:     true,
+								ValidateFunc: validation.StringInSlice([]string{"filesystem", "archive", "http"}, false),
+							},
+							"path": {
+								Type:     schema.TypeString,
+								Optional: true,
+							},
+							"passphrase": {
+								Type:      schema.TypeString,
+								Optional:  true,
+								Sensitive: true,
+							},
+							"url": {
+								Type:     schema.TypeString,
+								Optional: true,
+							},
+							"token": {
+								Type:      schema.TypeString,
+								Optional:  true,
+								Sensitive: true,
+							},
+						},
+					},
+				},
+				"store_in_state": {
+					Type:        schema.TypeBool,
+					Optional:    true,
+					Default:     false,
+					Description: "keep the machine stores in the Terraform state instead of storage_path",
+				},
+				"store_encryption_key": {
+					Type:          schema.TypeString,
+					Optional:      true,
+					Sensitive:     true,
+					DefaultFunc:   schema.EnvDefaultFunc("DOCKERMACHINE_STORE_ENCRYPTION_KEY", ""),
+					ConflictsWith: []string{"store_encryption_key_file"},
+					Description:   "key encrypting the private keys of the store under storage_path",
+				},
+				"store_encryption_key_file": {
+					Type:          schema.TypeString,
+					Optional:      true,
+					ConflictsWith: []string{"store_encryption_key"},
+					Description:   "file holding the key encrypting the private keys of the store under storage_path",
+				},
+				"lock_timeout": {
+					Type:         schema.TypeString,
+					Optional:     true,
+					Default:      "5m",
+					ValidateFunc: validateDuration,
+					Description:  "how long to wait for the locks of the store under storage_path",
+				},
+				"max_concurrent_creates": {
+					Type:         schema.TypeInt,
+					Optional:     true,
+					Default:      0,
+					ValidateFunc: validation.IntAtLeast(0),
+					Description:  "maximum number of machines created at the same time, 0 for no limit",
+				},
+				"max_concurrent_deletes": {
+					Type:         schema.TypeInt,
+					Optional:     true,
+					Default:      0,
+					ValidateFunc: validation.IntAtLeast(0),
+					Description:  "maximum number of machines deleted at the same time, 0 for no limit",
+				},
+				"driver_max_concurrent_creates": {
+					Type:     schema.TypeMap,
+					Optional: true,
+					Elem: &schema.Schema{
+						Type: schema.TypeInt,
+					},
+					Description: "maximum number of machines created at the same time, per driver",
+				},
+				"driver_max_concurrent_deletes": {
+					Type:     schema.TypeMap,
+					Optional: true,
+					Elem: &schema.Schema{
+						Type: schema.TypeInt,
+					},
+					Description: "maximum number of machines deleted at the same time, per driver",
+				},
+				"plugin_dirs": {
+					Type:     schema.TypeList,
+					Optional: true,
+					Elem: &schema.Schema{
+						Type: schema.TypeString,
+					},
+					Description: "additional directories containing docker-machine driver plugins, only usable through dockermachine_external; list them in DOCKERMACHINE_PLUGIN_DIRS for typed resources",
+				},
 			},
 		},
 	}
 }
 
+// warningProvider reports the driver discovery problems as warnings when the
+// provider configuration is validated, along with the plugins of plugin_dirs
+// that have no typed resource.
+type warningProvider struct {
+	*schema.Provider
+	warnings []string
+	// typedDrivers are the drivers with a dockermachine_<name> resource.
+	typedDrivers map[string]bool
+}
+
+func (p *warningProvider) Validate(c *terraform.ResourceConfig) ([]string, []error) {
+	warnings, errors := p.Provider.Validate(c)
+	warnings = append(warnings, p.warnings...)
+	// plugin_dirs is only known once the resources are declared, so the
+	// plugins found there can only be used through dockermachine_external.
+	if dirs, ok := c.Get("plugin_dirs"); ok {
+		if dirs, ok := dirs.([]interface{}); ok {
+			for _, dir := range dirs {
+				if dir, ok := dir.(string); ok {
+					warnings = append(warnings, p.untypedPluginWarnings(dir)...)
+				}
+			}
+		}
+	}
+	return warnings, errors
+}
+
+func (p *warningProvider) untypedPluginWarnings(dir string) []string {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var warnings []string
+	for _, file := range files {
+		name, ok := driverPluginName(file)
+		if !ok || p.typedDrivers[name] {
+			continue
+		}
+		warnings = append(warnings, fmt.Sprintf("Driver plugin %q of plugin_dirs can only be used through dockermachine_external: add %s to %s for a dockermachine_%s resource", name, dir, pluginDirsEnv, strings.Replace(name, "-", "_", -1)))
+	}
+	return warnings
+}
+
 func storagePathDefault() (interface{}, error) {
 	return mcndirs.GetBaseDir(), nil
 }
@@ -54,5 +228,77 @@ func certsDirDefault() (interface{}, error) {
 
 func providerConfigure(d *schema.ResourceData) (interface{}, error) {
 	log.SetDebug(d.Get("debug").(bool))
-	return libmachine.NewClient(d.Get("storage_path").(string), d.Get("certs_directory").(string)), nil
+	addPluginDirs(is2ss(d.Get("plugin_dirs").([]interface{})))
+	lockTimeout, err := time.ParseDuration(d.Get("lock_timeout").(string))
+	if err != nil {
+		return nil, err
+	}
+	backend, err := storeBackendConfigure(d, lockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	encryption, err := storeEncryptionConfigure(d)
+	if err != nil {
+		return nil, err
+	}
+	if encryption != nil {
+		if err := encryption.migrate(lockTimeout); err != nil {
+			return nil, err
+		}
+	}
+	createLimit := newConcurrencyLimit("create", d.Get("max_concurrent_creates").(int),
+		d.Get("driver_max_concurrent_creates").(map[string]interface{}))
+	deleteLimit := newConcurrencyLimit("delete", d.Get("max_concurrent_deletes").(int),
+		d.Get("driver_max_concurrent_deletes").(map[string]interface{}))
+	return &providerConfig{
+		client:       libmachine.NewClient(d.Get("storage_path").(string), d.Get("certs_directory").(string)),
+		backend:      backend,
+		storeInState: d.Get("store_in_state").(bool),
+		encryption:   encryption,
+		lockTimeout:  lockTimeout,
+		createLimit:  createLimit,
+		deleteLimit:  deleteLimit,
+	}, nil
+}
+
+// storeEncryptionConfigure returns the encryption of the store under
+// storage_path, or nil if no key is set.
+func storeEncryptionConfigure(d *schema.ResourceData) (*storeEncryption, error) {
+	key := d.Get("store_encryption_key").(string)
+	if file := d.Get("store_encryption_key_file").(string); file != "" {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading store encryption key: %s", err)
+		}
+		key = strings.TrimSpace(string(data))
+	}
+	if key == "" {
+		return nil, nil
+	}
+	return newStoreEncryption(key, d.Get("storage_path").(string))
+}
+
+// storeBackendConfigure returns the backend set in the store block, or nil
+// for the filesystem backend, which is the store under storage_path.
+func storeBackendConfigure(d *schema.ResourceData, lockTimeout time.Duration) (storeBackend, error) {
+	stores := d.Get("store").([]interface{})
+	if len(stores) == 0 || stores[0] == nil {
+		return nil, nil
+	}
+	store := stores[0].(map[string]interface{})
+	switch store["backend"].(string) {
+	case "archive":
+		path, passphrase := store["path"].(string), store["passphrase"].(string)
+		if path == "" || passphrase == "" {
+			return nil, fmt.Errorf("The archive store backend requires path and passphrase")
+		}
+		return newArchiveBackend(path, passphrase, lockTimeout)
+	case "http":
+		address := store["url"].(string)
+		if address == "" {
+			return nil, fmt.Errorf("The http store backend requires url")
+		}
+		return newHTTPBackend(address, store["token"].(string))
+	}
+	return nil, nil
 }
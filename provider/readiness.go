@@ -0,0 +1,169 @@
+package provider
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cenkalti/backoff"
+
+	"github.com/docker/machine/libmachine/host"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/helper/validation"
+)
+
+// readinessSchema is the wait_for_ready block of machine resources.
+func readinessSchema() *schema.Schema {
+	return &schema.Schema{
+		Type:     schema.TypeList,
+		Optional: true,
+		MaxItems: 1,
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				"ssh": {
+					Type:     schema.TypeBool,
+					Optional: true,
+					Default:  true,
+				},
+				"docker": {
+					Type:     schema.TypeBool,
+					Optional: true,
+					Default:  true,
+				},
+				"tcp_ports": {
+					Type:     schema.TypeList,
+					Optional: true,
+					Elem: &schema.Schema{
+						Type:         schema.TypeInt,
+						ValidateFunc: validation.IntBetween(1, 65535),
+					},
+				},
+				"timeout": {
+					Type:         schema.TypeString,
+					Optional:     true,
+					Default:      "5m",
+					ValidateFunc: validateDuration,
+				},
+			},
+		},
+	}
+}
+
+// readinessChecksSchema is the computed outcome of the readiness checks.
+func readinessChecksSchema() *schema.Schema {
+	return &schema.Schema{
+		Type:     schema.TypeList,
+		Computed: true,
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				"name": {
+					Type:     schema.TypeString,
+					Computed: true,
+				},
+				"ready": {
+					Type:     schema.TypeBool,
+					Computed: true,
+				},
+				"latency": {
+					Type:     schema.TypeString,
+					Computed: true,
+				},
+				"error": {
+					Type:     schema.TypeString,
+					Computed: true,
+				},
+			},
+		},
+	}
+}
+
+// readinessCheck tells whether a service of a machine is ready.
+type readinessCheck struct {
+	name  string
+	check func() error
+}
+
+// tcpDialTimeout bounds each attempt to connect to a TCP port.
+const tcpDialTimeout = 5 * time.Second
+
+// waitForReady polls the checks of the wait_for_ready block, one after the
+// other, with an exponential backoff, until they all pass or its timeout
+// expires, within the deadline of op. A check that hangs, e.g. an SSH
+// connection to a machine that does not answer, is abandoned once the timeout
+// expires. The outcome of each check is set into readiness_checks.
+func waitForReady(d *schema.ResourceData, h *host.Host, op *timedOperation) error {
+	blocks := d.Get("wait_for_ready").([]interface{})
+	if len(blocks) == 0 || blocks[0] == nil {
+		d.Set("readiness_checks", nil)
+		return nil
+	}
+	config := blocks[0].(map[string]interface{})
+	timeout, err := time.ParseDuration(config["timeout"].(string))
+	if err != nil {
+		return fmt.Errorf("Error parsing wait_for_ready timeout: %s", err)
+	}
+
+	var checks []readinessCheck
+	if config["ssh"].(bool) {
+		checks = append(checks, readinessCheck{"ssh", func() error {
+			_, err := h.RunSSHCommand("exit 0")
+			return err
+		}})
+	}
+	if config["docker"].(bool) {
+		checks = append(checks, readinessCheck{"docker", func() error {
+			_, err := h.DockerVersion()
+			return err
+		}})
+	}
+	for _, port := range config["tcp_ports"].([]interface{}) {
+		port := strconv.Itoa(port.(int))
+		checks = append(checks, readinessCheck{"tcp:" + port, func() error {
+			address, err := h.Driver.GetIP()
+			if err != nil {
+				return err
+			}
+			conn, err := net.DialTimeout("tcp", net.JoinHostPort(address, port), tcpDialTimeout)
+			if err != nil {
+				return err
+			}
+			return conn.Close()
+		}})
+	}
+
+	wait := op.child(fmt.Sprintf("waiting for machine %q to be ready", h.Name), timeout)
+	results := make([]interface{}, 0, len(checks))
+	var failed []string
+	for _, check := range checks {
+		start := time.Now()
+		err := backoff.Retry(func() error {
+			err := wait.run(fmt.Sprintf("readiness check (%s)", check.name), check.check)
+			if err != nil {
+				log.Printf("[DEBUG] Machine %q is not ready yet, %s check failed: %s", h.Name, check.name, err)
+			}
+			return err
+		}, wait.backoff())
+		result := map[string]interface{}{
+			"name":    check.name,
+			"ready":   err == nil,
+			"latency": (time.Since(start) / time.Millisecond * time.Millisecond).String(),
+			"error":   "",
+		}
+		if err != nil {
+			result["error"] = err.Error()
+			failed = append(failed, fmt.Sprintf("%s: %s", check.name, err))
+		}
+		results = append(results, result)
+	}
+	if err := d.Set("readiness_checks", results); err != nil {
+		return fmt.Errorf("Error setting readiness_checks: %s", err)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("Error waiting for machine %q to be ready after %s: %s", h.Name, timeout, strings.Join(failed, "; "))
+	}
+	return nil
+}
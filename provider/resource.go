@@ -2,6 +2,7 @@ package provider
 
 import (
 	"strings"
+	"time"
 
 	"github.com/docker/machine/libmachine/drivers"
 	"github.com/docker/machine/libmachine/drivers/rpc"
@@ -27,9 +28,70 @@ import (
 	"github.com/hashicorp/terraform/helper/validation"
 )
 
-func resource(driverName string) *schema.Resource {
-	drv := getDriver(driverName, "", "")
-	resourceSchema := map[string]*schema.Schema{
+func resource(driverName string, createFlags []mcnflag.Flag) *schema.Resource {
+	resourceSchema := commonSchema()
+	for _, flag := range createFlags {
+		flagName := strings.Replace(flag.String(), "-", "_", -1)
+		switch f := derefFlag(flag).(type) {
+		case mcnflag.StringFlag:
+			resourceSchema[flagName] = &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: true,
+				Default:  f.Value,
+			}
+		case mcnflag.StringSliceFlag:
+			resourceSchema[flagName] = &schema.Schema{
+				Type:     schema.TypeList,
+				Optional: true,
+				ForceNew: true,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			}
+		case mcnflag.IntFlag:
+			resourceSchema[flagName] = &schema.Schema{
+				Type:     schema.TypeInt,
+				Optional: true,
+				ForceNew: true,
+				Default:  f.Value,
+			}
+		case mcnflag.BoolFlag:
+			resourceSchema[flagName] = &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				ForceNew: true,
+			}
+		}
+	}
+	return &schema.Resource{
+		Schema:        resourceSchema,
+		Exists:        resourceExists(driverName),
+		Create:        resourceCreate(driverName),
+		Read:          resourceRead(driverName),
+		Update:        resourceUpdate(driverName),
+		Delete:        resourceDelete(driverName),
+		CustomizeDiff: resourceCustomizeDiff,
+		Timeouts:      resourceTimeouts(),
+		Importer: &schema.ResourceImporter{
+			State: resourceImport(driverName, createFlags),
+		},
+	}
+}
+
+// resourceTimeouts returns the default timeouts of machine resources.
+func resourceTimeouts() *schema.ResourceTimeout {
+	return &schema.ResourceTimeout{
+		Create: schema.DefaultTimeout(30 * time.Minute),
+		Update: schema.DefaultTimeout(20 * time.Minute),
+		Delete: schema.DefaultTimeout(10 * time.Minute),
+	}
+}
+
+// commonSchema returns the attributes shared by every machine resource,
+// regardless of the driver.
+func commonSchema() map[string]*schema.Schema {
+	return map[string]*schema.Schema{
 		"name": {
 			Type:     schema.TypeString,
 			Required: true,
@@ -77,13 +139,21 @@ func resource(driverName string) *schema.Resource {
 			Type:     schema.TypeString,
 			Computed: true,
 		},
+		"machine_store": {
+			Type:      schema.TypeString,
+			Computed:  true,
+			Sensitive: true,
+		},
 		"tls_san": {
 			Type:     schema.TypeList,
 			Optional: true,
 			Elem: &schema.Schema{
 				Type: schema.TypeString,
 			},
-			ForceNew: true,
+		},
+		"regenerate_certs": {
+			Type:     schema.TypeString,
+			Optional: true,
 		},
 		"engine_opt": {
 			Type:     schema.TypeList,
@@ -91,7 +161,6 @@ func resource(driverName string) *schema.Resource {
 			Elem: &schema.Schema{
 				Type: schema.TypeString,
 			},
-			ForceNew: true,
 		},
 		"engine_env": {
 			Type:     schema.TypeList,
@@ -99,7 +168,6 @@ func resource(driverName string) *schema.Resource {
 			Elem: &schema.Schema{
 				Type: schema.TypeString,
 			},
-			ForceNew: true,
 		},
 		"engine_insecure_registry": {
 			Type:     schema.TypeList,
@@ -107,7 +175,6 @@ func resource(driverName string) *schema.Resource {
 			Elem: &schema.Schema{
 				Type: schema.TypeString,
 			},
-			ForceNew: true,
 		},
 		"engine_label": {
 			Type:     schema.TypeList,
@@ -115,7 +182,6 @@ func resource(driverName string) *schema.Resource {
 			Elem: &schema.Schema{
 				Type: schema.TypeString,
 			},
-			ForceNew: true,
 		},
 		"engine_registry_mirror": {
 			Type:     schema.TypeList,
@@ -123,12 +189,10 @@ func resource(driverName string) *schema.Resource {
 			Elem: &schema.Schema{
 				Type: schema.TypeString,
 			},
-			ForceNew: true,
 		},
 		"engine_storage_driver": {
 			Type:     schema.TypeString,
 			Optional: true,
-			ForceNew: true,
 		},
 		"engine_install_url": {
 			Type:     schema.TypeString,
@@ -222,55 +286,119 @@ func resource(driverName string) *schema.Resource {
 			Type:     schema.TypeString,
 			Computed: true,
 		},
+		"ca_cert_pem": {
+			Type:      schema.TypeString,
+			Computed:  true,
+			Sensitive: true,
+		},
+		"client_cert_pem": {
+			Type:      schema.TypeString,
+			Computed:  true,
+			Sensitive: true,
+		},
+		"client_key_pem": {
+			Type:      schema.TypeString,
+			Computed:  true,
+			Sensitive: true,
+		},
+		"server_cert_pem": {
+			Type:      schema.TypeString,
+			Computed:  true,
+			Sensitive: true,
+		},
+		"server_key_pem": {
+			Type:      schema.TypeString,
+			Computed:  true,
+			Sensitive: true,
+		},
+		"server_cert_not_after": {
+			Type:     schema.TypeString,
+			Computed: true,
+		},
+		"client_cert_not_after": {
+			Type:     schema.TypeString,
+			Computed: true,
+		},
+		"cert_renew_before": {
+			Type:         schema.TypeString,
+			Optional:     true,
+			ValidateFunc: validateDuration,
+		},
+		"cert_renewal_required": {
+			Type:     schema.TypeBool,
+			Computed: true,
+		},
 		"state": {
 			Type:         schema.TypeString,
 			Optional:     true,
 			Default:      "running",
-			ValidateFunc: validation.StringInSlice([]string{"running", "stopped"}, false),
+			ValidateFunc: validation.StringInSlice([]string{"running", "stopped", "paused", "saved"}, false),
+		},
+		"wait_for_ready":   readinessSchema(),
+		"readiness_checks": readinessChecksSchema(),
+		"restart_trigger": {
+			Type:     schema.TypeString,
+			Optional: true,
+		},
+		"stop_mode": {
+			Type:         schema.TypeString,
+			Optional:     true,
+			Default:      "graceful",
+			ValidateFunc: validation.StringInSlice([]string{"graceful", "kill"}, false),
+		},
+		"stop_timeout": {
+			Type:         schema.TypeString,
+			Optional:     true,
+			Default:      "1m",
+			ValidateFunc: validateDuration,
+		},
+		"on_create_failure": {
+			Type:         schema.TypeString,
+			Optional:     true,
+			Default:      "fail",
+			ValidateFunc: validation.StringInSlice([]string{"destroy", "keep", "fail"}, false),
+		},
+		"adopt_existing": {
+			Type:     schema.TypeBool,
+			Optional: true,
+			Default:  false,
+		},
+		"force_remove": {
+			Type:     schema.TypeBool,
+			Optional: true,
+			Default:  false,
+		},
+		"prevent_destroy_if_containers_running": {
+			Type:     schema.TypeBool,
+			Optional: true,
+			Default:  false,
+		},
+		"drain_before_destroy": {
+			Type:     schema.TypeBool,
+			Optional: true,
+			Default:  false,
+		},
+		"deletion_protection": {
+			Type:     schema.TypeBool,
+			Optional: true,
+			Default:  false,
+		},
+		"on_destroy": {
+			Type:         schema.TypeString,
+			Optional:     true,
+			Default:      "remove",
+			ValidateFunc: validation.StringInSlice([]string{"remove", "abandon", "stop"}, false),
 		},
 	}
-	for _, flag := range drv.GetCreateFlags() {
-		flagName := strings.Replace(flag.String(), "-", "_", -1)
-		switch f := flag.(type) {
-		case mcnflag.StringFlag:
-			resourceSchema[flagName] = &schema.Schema{
-				Type:     schema.TypeString,
-				Optional: true,
-				ForceNew: true,
-				Default:  f.Value,
-			}
-		case mcnflag.StringSliceFlag:
-			resourceSchema[flagName] = &schema.Schema{
-				Type:     schema.TypeList,
-				Optional: true,
-				ForceNew: true,
-				Elem: &schema.Schema{
-					Type: schema.TypeString,
-				},
-			}
-		case mcnflag.IntFlag:
-			resourceSchema[flagName] = &schema.Schema{
-				Type:     schema.TypeInt,
-				Optional: true,
-				ForceNew: true,
-				Default:  f.Value,
-			}
-		case mcnflag.BoolFlag:
-			resourceSchema[flagName] = &schema.Schema{
-				Type:     schema.TypeBool,
-				Optional: true,
-				ForceNew: true,
-			}
-		}
-	}
-	return &schema.Resource{
-		Schema: resourceSchema,
-		Exists: resourceExists(drv.DriverName()),
-		Create: resourceCreate(drv.DriverName()),
-		Read:   resourceRead(drv.DriverName()),
-		Update: resourceUpdate(drv.DriverName()),
-		Delete: resourceDelete(drv.DriverName()),
+}
+
+// resourceCustomizeDiff plans an update of the machines whose server
+// certificate has been found to need renewal upon refresh.
+func resourceCustomizeDiff(d *schema.ResourceDiff, meta interface{}) error {
+	if d.Get("cert_renewal_required").(bool) {
+		return d.SetNew("cert_renewal_required", false)
 	}
+	return nil
 }
 
 func getDriver(driverName, machineName, storePath string) drivers.Driver {
@@ -0,0 +1,309 @@
+package provider
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/helper/validation"
+)
+
+// Certificate files, named as in the docker-machine certificates directory.
+const (
+	caCertFile     = "ca.pem"
+	caKeyFile      = "ca-key.pem"
+	clientCertFile = "cert.pem"
+	clientKeyFile  = "key.pem"
+)
+
+// resourceCertificates manages the CA and client certificates that
+// docker-machine otherwise generates the first time a machine is created.
+// Machines use them through their tls_ca_* and tls_client_* attributes.
+func resourceCertificates() *schema.Resource {
+	return &schema.Resource{
+		Create: resourceCertificatesCreate,
+		Read:   resourceCertificatesRead,
+		Delete: resourceCertificatesDelete,
+		Importer: &schema.ResourceImporter{
+			State: resourceCertificatesImport,
+		},
+		Schema: map[string]*schema.Schema{
+			"directory": {
+				Type:     schema.TypeString,
+				Required: true,
+				ForceNew: true,
+			},
+			"organization": {
+				Type:     schema.TypeString,
+				Required: true,
+				ForceNew: true,
+			},
+			"rsa_bits": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				ForceNew:     true,
+				Default:      2048,
+				ValidateFunc: validation.IntAtLeast(1024),
+			},
+			"validity_period_hours": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				ForceNew:     true,
+				Default:      25920,
+				ValidateFunc: validation.IntAtLeast(1),
+			},
+			"ca_cert_pem": {
+				Type:     schema.TypeString,
+				Optional: true,
+				Computed: true,
+				ForceNew: true,
+			},
+			"ca_key_pem": {
+				Type:      schema.TypeString,
+				Optional:  true,
+				Computed:  true,
+				ForceNew:  true,
+				Sensitive: true,
+			},
+			"client_cert_pem": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"client_key_pem": {
+				Type:      schema.TypeString,
+				Computed:  true,
+				Sensitive: true,
+			},
+			"ca_cert": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"ca_key": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"client_cert": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"client_key": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"ca_cert_fingerprint": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"client_cert_fingerprint": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+		},
+	}
+}
+
+func resourceCertificatesCreate(d *schema.ResourceData, meta interface{}) error {
+	directory := d.Get("directory").(string)
+	org := d.Get("organization").(string)
+	bits := d.Get("rsa_bits").(int)
+	validity := time.Duration(d.Get("validity_period_hours").(int)) * time.Hour
+
+	for _, file := range []string{caCertFile, caKeyFile, clientCertFile, clientKeyFile} {
+		if _, err := os.Stat(filepath.Join(directory, file)); err == nil {
+			return fmt.Errorf("Error creating certificates: %s already exists, import it instead", filepath.Join(directory, file))
+		}
+	}
+
+	caCertPEM := []byte(d.Get("ca_cert_pem").(string))
+	caKeyPEM := []byte(d.Get("ca_key_pem").(string))
+	if (len(caCertPEM) == 0) != (len(caKeyPEM) == 0) {
+		return fmt.Errorf("Error creating certificates: ca_cert_pem and ca_key_pem must be set together")
+	}
+	if len(caCertPEM) == 0 {
+		var err error
+		caCertPEM, caKeyPEM, err = generateCertificate(nil, nil, org, bits, validity)
+		if err != nil {
+			return fmt.Errorf("Error generating CA certificate: %s", err)
+		}
+	}
+	caCert, caKey, err := parseKeyPair(caCertPEM, caKeyPEM)
+	if err != nil {
+		return fmt.Errorf("Error reading CA certificate: %s", err)
+	}
+	clientCertPEM, clientKeyPEM, err := generateCertificate(caCert, caKey, org, bits, validity)
+	if err != nil {
+		return fmt.Errorf("Error generating client certificate: %s", err)
+	}
+
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		return fmt.Errorf("Error creating certificates directory: %s", err)
+	}
+	files := []struct {
+		name string
+		data []byte
+		mode os.FileMode
+	}{
+		{caCertFile, caCertPEM, 0644},
+		{caKeyFile, caKeyPEM, 0600},
+		{clientCertFile, clientCertPEM, 0644},
+		{clientKeyFile, clientKeyPEM, 0600},
+	}
+	for _, file := range files {
+		if err := ioutil.WriteFile(filepath.Join(directory, file.name), file.data, file.mode); err != nil {
+			return fmt.Errorf("Error writing certificates: %s", err)
+		}
+	}
+
+	d.SetId(directory)
+
+	return resourceCertificatesRead(d, meta)
+}
+
+func resourceCertificatesRead(d *schema.ResourceData, meta interface{}) error {
+	directory := d.Id()
+	pems := make(map[string][]byte)
+	for _, file := range []string{caCertFile, caKeyFile, clientCertFile, clientKeyFile} {
+		data, err := ioutil.ReadFile(filepath.Join(directory, file))
+		if os.IsNotExist(err) {
+			d.SetId("")
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("Error reading certificates: %s", err)
+		}
+		pems[file] = data
+	}
+
+	caCert, _, err := parseKeyPair(pems[caCertFile], pems[caKeyFile])
+	if err != nil {
+		return fmt.Errorf("Error reading CA certificate: %s", err)
+	}
+	clientCert, clientKey, err := parseKeyPair(pems[clientCertFile], pems[clientKeyFile])
+	if err != nil {
+		return fmt.Errorf("Error reading client certificate: %s", err)
+	}
+
+	d.Set("directory", directory)
+	if len(clientCert.Subject.Organization) > 0 {
+		d.Set("organization", clientCert.Subject.Organization[0])
+	}
+	d.Set("rsa_bits", clientKey.N.BitLen())
+	d.Set("validity_period_hours", int(clientCert.NotAfter.Sub(clientCert.NotBefore)/time.Hour))
+	d.Set("ca_cert_pem", string(pems[caCertFile]))
+	d.Set("ca_key_pem", string(pems[caKeyFile]))
+	d.Set("client_cert_pem", string(pems[clientCertFile]))
+	d.Set("client_key_pem", string(pems[clientKeyFile]))
+	d.Set("ca_cert", filepath.Join(directory, caCertFile))
+	d.Set("ca_key", filepath.Join(directory, caKeyFile))
+	d.Set("client_cert", filepath.Join(directory, clientCertFile))
+	d.Set("client_key", filepath.Join(directory, clientKeyFile))
+	d.Set("ca_cert_fingerprint", fingerprint(caCert))
+	d.Set("client_cert_fingerprint", fingerprint(clientCert))
+	return nil
+}
+
+func resourceCertificatesDelete(d *schema.ResourceData, meta interface{}) error {
+	directory := d.Id()
+	for _, file := range []string{caCertFile, caKeyFile, clientCertFile, clientKeyFile} {
+		if err := os.Remove(filepath.Join(directory, file)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("Error removing certificates: %s", err)
+		}
+	}
+	// Leave the directory in place if anything else was stored in it.
+	os.Remove(directory)
+	return nil
+}
+
+func resourceCertificatesImport(d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+	if err := resourceCertificatesRead(d, meta); err != nil {
+		return nil, err
+	}
+	if d.Id() == "" {
+		return nil, fmt.Errorf("Error importing certificates: no certificates found in the directory")
+	}
+	return []*schema.ResourceData{d}, nil
+}
+
+// generateCertificate generates an RSA key and a certificate for it, which is
+// a self-signed CA certificate when no CA is given, or a client certificate
+// signed by the CA otherwise. Both are returned PEM encoded.
+func generateCertificate(caCert *x509.Certificate, caKey *rsa.PrivateKey, org string, bits int, validity time.Duration) ([]byte, []byte, error) {
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		return nil, nil, err
+	}
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			Organization: []string{org},
+		},
+		NotBefore:             now,
+		NotAfter:              now.Add(validity),
+		BasicConstraintsValid: true,
+	}
+	if caCert == nil {
+		template.IsCA = true
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature
+		caCert, caKey = template, key
+	} else {
+		template.KeyUsage = x509.KeyUsageDigitalSignature
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	return certPEM, keyPEM, nil
+}
+
+// parseKeyPair decodes a PEM encoded certificate and its RSA private key.
+func parseKeyPair(certPEM, keyPEM []byte) (*x509.Certificate, *rsa.PrivateKey, error) {
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil {
+		return nil, nil, fmt.Errorf("no PEM data found in certificate")
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyBlock, _ := pem.Decode(keyPEM)
+	if keyBlock == nil {
+		return nil, nil, fmt.Errorf("no PEM data found in key")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(keyBlock.Bytes); err == nil {
+		return cert, key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, nil, fmt.Errorf("key is not an RSA key")
+	}
+	return cert, key, nil
+}
+
+// fingerprint returns the SHA-256 fingerprint of a certificate.
+func fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,220 @@
+package provider
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+func certificatesData(t *testing.T, raw map[string]interface{}) *schema.ResourceData {
+	config := map[string]interface{}{
+		"organization": "test",
+		"rsa_bits":     1024,
+	}
+	for k, v := range raw {
+		config[k] = v
+	}
+	return schema.TestResourceDataRaw(t, resourceCertificates().Schema, config)
+}
+
+func parseCertificate(t *testing.T, data string) *x509.Certificate {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		t.Fatalf("no PEM data found in %q", data)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// checkCertificates checks the attributes of certificates read from
+// directory, and that the client certificate is signed by the CA.
+func checkCertificates(t *testing.T, name string, d *schema.ResourceData, directory string) {
+	if d.Id() != directory {
+		t.Errorf("%s: expected ID %q, got %q", name, directory, d.Id())
+	}
+	for attribute, file := range map[string]string{
+		"ca_cert":     caCertFile,
+		"ca_key":      caKeyFile,
+		"client_cert": clientCertFile,
+		"client_key":  clientKeyFile,
+	} {
+		path := filepath.Join(directory, file)
+		if d.Get(attribute).(string) != path {
+			t.Errorf("%s: expected %s to be %q, got %q", name, attribute, path, d.Get(attribute))
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		if d.Get(attribute+"_pem").(string) != string(data) {
+			t.Errorf("%s: expected %s_pem to be the contents of %s", name, attribute, file)
+		}
+	}
+	for _, file := range []string{caKeyFile, clientKeyFile} {
+		if info, err := os.Stat(filepath.Join(directory, file)); err == nil && info.Mode().Perm()&0077 != 0 {
+			t.Errorf("%s: expected %s to be private, got mode %s", name, file, info.Mode())
+		}
+	}
+
+	caCert := parseCertificate(t, d.Get("ca_cert_pem").(string))
+	clientCert := parseCertificate(t, d.Get("client_cert_pem").(string))
+	if err := clientCert.CheckSignatureFrom(caCert); err != nil {
+		t.Errorf("%s: expected the client certificate to be signed by the CA: %s", name, err)
+	}
+	if d.Get("ca_cert_fingerprint").(string) != fingerprint(caCert) {
+		t.Errorf("%s: expected ca_cert_fingerprint to be %s, got %s", name, fingerprint(caCert), d.Get("ca_cert_fingerprint"))
+	}
+	if d.Get("client_cert_fingerprint").(string) != fingerprint(clientCert) {
+		t.Errorf("%s: expected client_cert_fingerprint to be %s, got %s", name, fingerprint(clientCert), d.Get("client_cert_fingerprint"))
+	}
+	if d.Get("organization").(string) != "test" {
+		t.Errorf("%s: expected organization %q, got %q", name, "test", d.Get("organization"))
+	}
+	if d.Get("rsa_bits").(int) != 1024 {
+		t.Errorf("%s: expected rsa_bits 1024, got %d", name, d.Get("rsa_bits"))
+	}
+}
+
+func TestCertificatesCreate(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	directory := filepath.Join(dir, "certs")
+	d := certificatesData(t, map[string]interface{}{"directory": directory})
+	if err := resourceCertificatesCreate(d, nil); err != nil {
+		t.Fatal(err)
+	}
+	checkCertificates(t, "created", d, directory)
+	caCert := parseCertificate(t, d.Get("ca_cert_pem").(string))
+	if !caCert.IsCA {
+		t.Error("expected a CA certificate")
+	}
+
+	// Certificates are imported by directory.
+	imported := certificatesData(t, nil)
+	imported.SetId(directory)
+	if _, err := resourceCertificatesImport(imported, nil); err != nil {
+		t.Fatal(err)
+	}
+	checkCertificates(t, "imported", imported, directory)
+	if imported.Get("validity_period_hours").(int) != 25920 {
+		t.Errorf("expected validity_period_hours 25920, got %d", imported.Get("validity_period_hours"))
+	}
+
+	// Existing certificates are never overwritten.
+	again := certificatesData(t, map[string]interface{}{"directory": directory})
+	if err := resourceCertificatesCreate(again, nil); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("expected existing certificates to be refused, got %v", err)
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(directory, caCertFile)); string(data) != d.Get("ca_cert_pem").(string) {
+		t.Error("expected the existing CA certificate to be left alone")
+	}
+
+	// Certificates removed outside of Terraform are removed from the state.
+	if err := resourceCertificatesDelete(d, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(directory); !os.IsNotExist(err) {
+		t.Errorf("expected the directory to be removed, got %v", err)
+	}
+	if err := resourceCertificatesRead(imported, nil); err != nil {
+		t.Fatal(err)
+	}
+	if imported.Id() != "" {
+		t.Errorf("expected removed certificates to be removed from the state, got ID %q", imported.Id())
+	}
+}
+
+func TestCertificatesCreateWithCA(t *testing.T) {
+	caCertPEM, caKeyPEM, err := generateCertificate(nil, nil, "test", 1024, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	d := certificatesData(t, map[string]interface{}{
+		"directory":   dir,
+		"ca_cert_pem": string(caCertPEM),
+		"ca_key_pem":  string(caKeyPEM),
+	})
+	if err := resourceCertificatesCreate(d, nil); err != nil {
+		t.Fatal(err)
+	}
+	checkCertificates(t, "created with CA", d, dir)
+	if d.Get("ca_cert_pem").(string) != string(caCertPEM) || d.Get("ca_key_pem").(string) != string(caKeyPEM) {
+		t.Error("expected the given CA to be used")
+	}
+}
+
+var certificatesCreateErrorTests = []struct {
+	name   string
+	config map[string]interface{}
+	files  []string
+	err    string
+}{
+	{
+		name:  "existing CA certificate",
+		files: []string{caCertFile},
+		err:   "already exists",
+	},
+	{
+		name:  "existing client key",
+		files: []string{clientKeyFile},
+		err:   "already exists",
+	},
+	{
+		name:   "CA certificate without key",
+		config: map[string]interface{}{"ca_cert_pem": "cert"},
+		err:    "must be set together",
+	},
+	{
+		name:   "invalid CA",
+		config: map[string]interface{}{"ca_cert_pem": "cert", "ca_key_pem": "key"},
+		err:    "Error reading CA certificate",
+	},
+}
+
+func TestCertificatesCreateErrors(t *testing.T) {
+	for _, test := range certificatesCreateErrorTests {
+		dir := tempDir(t)
+		for _, file := range test.files {
+			if err := ioutil.WriteFile(filepath.Join(dir, file), []byte("existing"), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		config := map[string]interface{}{"directory": dir}
+		for k, v := range test.config {
+			config[k] = v
+		}
+		d := certificatesData(t, config)
+		if err := resourceCertificatesCreate(d, nil); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected an error containing %q, got %v", test.name, test.err, err)
+		}
+		for _, file := range test.files {
+			if data, _ := ioutil.ReadFile(filepath.Join(dir, file)); string(data) != "existing" {
+				t.Errorf("%s: expected %s to be left alone", test.name, file)
+			}
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func TestCertificatesImportEmpty(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	d := certificatesData(t, nil)
+	d.SetId(dir)
+	if _, err := resourceCertificatesImport(d, nil); err == nil {
+		t.Error("expected importing a directory without certificates to fail")
+	}
+}
@@ -3,16 +3,20 @@ package provider
 import (
 	"encoding/json"
 	"fmt"
+	"log"
 	"path/filepath"
-	"strings"
 	"time"
 
-	"github.com/docker/machine/libmachine"
 	"github.com/docker/machine/libmachine/auth"
+	"github.com/docker/machine/libmachine/cert"
 	"github.com/docker/machine/libmachine/crashreport"
+	"github.com/docker/machine/libmachine/drivers"
 	"github.com/docker/machine/libmachine/engine"
 	"github.com/docker/machine/libmachine/host"
 	"github.com/docker/machine/libmachine/mcnerror"
+	"github.com/docker/machine/libmachine/mcnflag"
+	"github.com/docker/machine/libmachine/mcnutils"
+	"github.com/docker/machine/libmachine/provision"
 	"github.com/docker/machine/libmachine/state"
 	"github.com/docker/machine/libmachine/swarm"
 
@@ -20,21 +24,31 @@ import (
 )
 
 func resourceCreate(driverName string) func(*schema.ResourceData, interface{}) error {
-	return func(d *schema.ResourceData, meta interface{}) error {
-		client := meta.(*libmachine.Client)
+	return func(d *schema.ResourceData, meta interface{}) (err error) {
 		name := d.Get("name").(string)
+		op := newTimedOperation(fmt.Sprintf("creating machine %q", name), d.Timeout(schema.TimeoutCreate))
+		client, closeStore, err := openStore(d, meta, op)
+		if err != nil {
+			return err
+		}
+		defer func() { err = closeStore(err) }()
 		if !host.ValidateHostName(name) {
 			return fmt.Errorf("Error creating machine: %s", mcnerror.ErrInvalidHostname)
 		}
-		drv := getDriver(driverName, name, client.Path)
-		data, err := json.Marshal(drv)
-
-		h, err := client.NewHost(drv.DriverName(), data)
+		h, err := newHost(client, driverName, name, d)
 		if err != nil {
 			return err
 		}
 
 		storagePath := d.Get("storage_path").(string)
+		if config := meta.(*providerConfig); config.storeInState || config.backend != nil {
+			if storagePath != "" {
+				return fmt.Errorf("Error creating machine: storage_path cannot be set when machine stores are kept in the state or in a store backend")
+			}
+			if localDrivers[h.DriverName] {
+				return fmt.Errorf("Error creating machine: the %s driver keeps the disks of its machines in the store, which is not kept when machine stores are kept in the state or in a store backend", h.DriverName)
+			}
+		}
 		if storagePath == "" {
 			storagePath = filepath.Join(client.Path, "machines", name)
 		}
@@ -89,18 +103,56 @@ func resourceCreate(driverName string) func(*schema.ResourceData, interface{}) e
 			return fmt.Errorf("Error checking if host exists: %s", err)
 		}
 		if exists {
+			if d.Get("adopt_existing").(bool) {
+				return adoptMachine(d, client, driverName)
+			}
 			return mcnerror.ErrHostAlreadyExists{
 				Name: h.Name,
 			}
 		}
 
-		driverOpts := getDriverOpts(d, h.Driver.GetCreateFlags())
+		var driverOpts drivers.DriverOptions
+		if driverName == "" {
+			driverOpts, err = getExternalDriverOpts(d, h.Driver.GetCreateFlags())
+			if err != nil {
+				return err
+			}
+		} else {
+			driverOpts = getDriverOpts(d, h.Driver.GetCreateFlags())
+		}
 
 		if err := h.Driver.SetConfigFromFlags(driverOpts); err != nil {
 			return fmt.Errorf("Error setting machine configuration from flags provided: %s", err)
 		}
+		op.useKeys(client, h)
 
-		if err := client.Create(h); err != nil {
+		if err := bootstrapCertificates(meta.(*providerConfig), client, h); err != nil {
+			return err
+		}
+
+		defer func() {
+			if err != nil && d.Id() == "" {
+				err = onCreateFailure(d, client, h, op, err)
+			}
+		}()
+
+		release, err := meta.(*providerConfig).createLimit.acquire(h.DriverName, name, op)
+		if err != nil {
+			return err
+		}
+		err = op.run("machine creation", func() error {
+			return createMachine(client, h, op)
+		})
+		if !op.afterAbandoned(release) {
+			release()
+		}
+		if _, ok := err.(*timeoutError); ok {
+			// The machine may exist by now: keep it tracked, so that
+			// Terraform destroys it before creating it again.
+			d.SetId(name)
+			return err
+		}
+		if err != nil {
 			time.Sleep(2 * time.Second)
 
 			vBoxLog := ""
@@ -120,6 +172,9 @@ func resourceCreate(driverName string) func(*schema.ResourceData, interface{}) e
 		if err := client.Save(h); err != nil {
 			return fmt.Errorf("Error attempting to save store: %s", err)
 		}
+		// The machine exists from now on: should a check below fail, it is
+		// kept in the state, tainted, rather than left untracked.
+		d.SetId(name)
 
 		d.Set("ssh_username", h.Driver.GetSSHUsername())
 		d.Set("ssh_keypath", h.Driver.GetSSHKeyPath())
@@ -133,59 +188,212 @@ func resourceCreate(driverName string) func(*schema.ResourceData, interface{}) e
 		case state.Error:
 			return fmt.Errorf("Machine is in error state")
 		}
-		switch d.Get("state").(string) {
-		case "running":
-			switch machineState {
-			case state.Paused, state.Saved, state.Stopped, state.Stopping:
-				if err = h.Start(); err != nil {
-					return fmt.Errorf("Error while attempting to start machine: %s", err)
-				}
-			}
-		case "stopped":
-			switch machineState {
-			case state.Running, state.Starting:
-				if err = h.Stop(); err != nil {
-					return fmt.Errorf("Error while attempting to stop machine: %s", err)
-				}
-			}
-		}
-		machineState, err = h.Driver.GetState()
-		if err != nil {
-			return fmt.Errorf("Error attempting to retrieve state: %s", err)
+		if err := setMachineState(d, h, machineState, op); err != nil {
+			return err
 		}
-		if machineState == state.Running {
-			sshHostname, err := h.Driver.GetSSHHostname()
-			if err != nil {
-				return fmt.Errorf("Error attempting to retrieve ssh hostname: %s", err)
-			}
-			d.Set("ssh_hostname", sshHostname)
-			sshPort, err := h.Driver.GetSSHPort()
-			if err != nil {
-				return fmt.Errorf("Error attempting to retrieve ssh port: %s", err)
+		if d.Get("state").(string) == "running" {
+			if err := waitForReady(d, h, op); err != nil {
+				return err
 			}
-			d.Set("ssh_port", sshPort)
-			address, err := h.Driver.GetIP()
-			if err != nil {
-				return fmt.Errorf("Error attempting to retrieve address: %s", err)
-			}
-			d.Set("address", address)
-			dockerUrl, err := h.Driver.GetURL()
-			if err != nil {
-				return fmt.Errorf("Error attempting to retrieve docker url: %s", err)
+		}
+		return client.withKeys(h, func() error {
+			if err := readTLSMaterial(d, h); err != nil {
+				return err
 			}
-			d.Set("docker_url", dockerUrl)
-			dockerVersion, err := h.DockerVersion()
+			return readMachine(d, h)
+		})
+	}
+}
+
+// adoptMachine takes ownership of the existing machine of the resource,
+// provided it uses the driver of the resource. The attributes are set from the
+// stored configuration, so that the next plan shows where the machine differs
+// from the resource.
+func adoptMachine(d *schema.ResourceData, client *machineStore, driverName string) error {
+	name := d.Get("name").(string)
+	h, err := client.Load(name)
+	if err != nil {
+		return fmt.Errorf("Error loading existing machine %q: %s", name, err)
+	}
+	var createFlags []mcnflag.Flag
+	if driverName == "" {
+		driverName = d.Get("driver").(string)
+	} else {
+		createFlags = h.Driver.GetCreateFlags()
+	}
+	if h.DriverName != driverName {
+		return fmt.Errorf("Error adopting machine %q: it uses the %s driver, not %s", name, h.DriverName, driverName)
+	}
+	log.Printf("[INFO] Adopting existing machine %q", name)
+
+	if err := setHostAttributes(d, client, h); err != nil {
+		return err
+	}
+	if err := setDriverFlags(d, h, createFlags, false); err != nil {
+		return err
+	}
+	err = client.withKeys(h, func() error {
+		if err := readTLSMaterial(d, h); err != nil {
+			return err
+		}
+		return readMachine(d, h)
+	})
+	if err != nil {
+		return err
+	}
+	d.SetId(name)
+	return nil
+}
+
+// onCreateFailure handles a machine whose creation failed with cause, as set
+// by the on_create_failure attribute: "destroy" removes the machine, "keep"
+// tracks it, so that Terraform marks it tainted, and "fail" leaves it alone.
+func onCreateFailure(d *schema.ResourceData, client *machineStore, h *host.Host, op *timedOperation, cause error) error {
+	exists, err := client.Exists(h.Name)
+	if err != nil || !exists {
+		return cause
+	}
+	switch d.Get("on_create_failure").(string) {
+	case "destroy":
+		log.Printf("[WARN] Creation of machine %q failed, destroying it: %s", h.Name, cause)
+		if op.currentPhase() != phasePreCreateCheck {
+			// The removal gets the delete timeout, within what is left of
+			// the creation, and a removal still running past it keeps
+			// the store open as the abandoned calls of the creation do.
+			remove := op.child(fmt.Sprintf("destroying machine %q", h.Name), d.Timeout(schema.TimeoutDelete))
+			err := remove.run("driver remove", func() error {
+				return removeMachine(h, remove)
+			})
 			if err != nil {
-				return fmt.Errorf("Error attempting to retrieve docker version: %s", err)
+				d.SetId(h.Name)
+				return fmt.Errorf("%s\nThe machine could not be destroyed, and is kept tainted: %s", cause, err)
 			}
-			d.Set("docker_version", dockerVersion)
 		}
-		d.Set("state", strings.ToLower(machineState.String()))
+		if err := client.Remove(h.Name); err != nil {
+			return fmt.Errorf("%s\nThe machine could not be removed from the store: %s", cause, err)
+		}
+	case "keep":
+		log.Printf("[WARN] Creation of machine %q failed, keeping it tainted: %s", h.Name, cause)
+		d.SetId(h.Name)
+	default:
+		log.Printf("[WARN] Creation of machine %q failed, leaving it in the store: %s", h.Name, cause)
+	}
+	return cause
+}
 
-		d.SetId(name)
+// localDrivers are the drivers running machines on the local host, from disk
+// images in the machine directory of the store.
+var localDrivers = map[string]bool{
+	"hyperkit":          true,
+	"hyperv":            true,
+	"kvm":               true,
+	"parallels":         true,
+	"virtualbox":        true,
+	"vmware":            true,
+	"vmwarefusion":      true,
+	"vmwareworkstation": true,
+	"xhyve":             true,
+}
+
+// phasePreCreateCheck is the phase of a creation before anything is created.
+const phasePreCreateCheck = "pre-create check"
 
+// createMachine creates the machine of h, as client.Create does once the
+// certificates are bootstrapped, recording the phases into op.
+func createMachine(client *machineStore, h *host.Host, op *timedOperation) error {
+	op.setPhase(phasePreCreateCheck)
+	if err := h.Driver.PreCreateCheck(); err != nil {
+		return mcnerror.ErrDuringPreCreate{
+			Cause: err,
+		}
+	}
+	if err := client.Save(h); err != nil {
+		return fmt.Errorf("Error saving host to store before attempting creation: %s", err)
+	}
+
+	op.setPhase("driver create")
+	if err := h.Driver.Create(); err != nil {
+		return fmt.Errorf("Error in driver during machine creation: %s", err)
+	}
+	if err := client.Save(h); err != nil {
+		return fmt.Errorf("Error saving host to store after attempting creation: %s", err)
+	}
+	if h.Driver.DriverName() == "none" {
 		return nil
 	}
+
+	op.setPhase("wait for the machine to run")
+	if err := mcnutils.WaitFor(drivers.MachineInState(h.Driver, state.Running)); err != nil {
+		return fmt.Errorf("Error waiting for machine to be running: %s", err)
+	}
+
+	op.setPhase("wait for SSH")
+	if err := drivers.WaitForSSH(h.Driver); err != nil {
+		return fmt.Errorf("Error waiting for SSH: %s", err)
+	}
+
+	op.setPhase("provisioner detection")
+	provisioner, err := provision.DetectProvisioner(h.Driver)
+	if err != nil {
+		return fmt.Errorf("Error detecting OS: %s", err)
+	}
+
+	op.setPhase("provisioning (engine installation and certificate copy)")
+	if err := provisioner.Provision(*h.HostOptions.SwarmOptions, *h.HostOptions.AuthOptions, *h.HostOptions.EngineOptions); err != nil {
+		return fmt.Errorf("Error running provisioning: %s", err)
+	}
+	return nil
+}
+
+// newHost prepares a host for the given driver. An empty driverName denotes
+// the external resource, whose driver plugin is named by the "driver"
+// attribute. Drivers that are not embedded into the provider are started with
+// just the base driver configuration.
+func newHost(client *machineStore, driverName, name string, d *schema.ResourceData) (*host.Host, error) {
+	if driverName == "" {
+		driverName = d.Get("driver").(string)
+	}
+	var drv interface{} = &drivers.BaseDriver{
+		MachineName: name,
+		StorePath:   client.Path,
+	}
+	if coreDriver := getDriver(driverName, name, client.Path); coreDriver != nil {
+		drv = coreDriver
+	}
+	data, err := json.Marshal(drv)
+	if err != nil {
+		return nil, fmt.Errorf("Error serializing driver configuration: %s", err)
+	}
+	return client.NewHost(driverName, data)
+}
+
+// bootstrapCertificates creates the CA and client certificates of a machine
+// if they do not exist yet. In the store under storage_path, the certificates
+// are shared by all the machines, so they are bootstrapped under a global
+// lock. In a store backend, they are shared through the backend, which may
+// already have received those of a machine created concurrently.
+func bootstrapCertificates(config *providerConfig, client *machineStore, h *host.Host) error {
+	if config.backend == nil && !config.storeInState {
+		lock, err := acquireLock(config.client.Path, certsLockName, config.lockTimeout)
+		if err != nil {
+			return err
+		}
+		defer lock.release()
+	}
+	// The keys generated into an encrypted store are encrypted before the
+	// lock is released.
+	err := client.withKeys(h, func() error {
+		return cert.BootstrapCertificates(h.AuthOptions())
+	})
+	if err != nil {
+		return fmt.Errorf("Error generating certificates: %s", err)
+	}
+	if config.backend != nil && !config.storeInState {
+		if err := config.backend.shareCerts(client.Path, h.Name); err != nil {
+			return fmt.Errorf("Error sharing certificates through the store: %s", err)
+		}
+	}
+	return nil
 }
 
 func tlsPath(d *schema.ResourceData, option, directory, defaultValue string) string {
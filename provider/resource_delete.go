@@ -2,33 +2,211 @@ package provider
 
 import (
 	"fmt"
+	"log"
+	"strings"
 
-	"github.com/docker/machine/libmachine"
+	"github.com/cenkalti/backoff"
+	"github.com/samalba/dockerclient"
+
+	"github.com/docker/machine/libmachine/host"
+	"github.com/docker/machine/libmachine/mcndockerclient"
+	"github.com/docker/machine/libmachine/mcnerror"
+	"github.com/docker/machine/libmachine/state"
 
 	"github.com/hashicorp/terraform/helper/schema"
 )
 
 func resourceDelete(driverName string) func(*schema.ResourceData, interface{}) error {
-	return func(d *schema.ResourceData, meta interface{}) error {
-		client := meta.(*libmachine.Client)
+	return func(d *schema.ResourceData, meta interface{}) (err error) {
 		name := d.Get("name").(string)
-		var err error
-		host, err := client.Load(name)
+		op := newTimedOperation(fmt.Sprintf("deleting machine %q", name), d.Timeout(schema.TimeoutDelete))
+		client, closeStore, err := openStore(d, meta, op)
 		if err != nil {
 			return err
 		}
-		err = host.Driver.Remove()
+		defer func() { err = closeStore(err) }()
+		if d.Get("deletion_protection").(bool) {
+			return fmt.Errorf("Error deleting machine %q: deletion_protection is set, it must be unset and applied before the machine can be destroyed", name)
+		}
+		onDestroy := d.Get("on_destroy").(string)
+		if onDestroy == "abandon" {
+			log.Printf("[INFO] Abandoning machine %q: it is left running and in the store", name)
+			return nil
+		}
+		h, err := client.Load(name)
+		if _, ok := err.(mcnerror.ErrHostDoesNotExist); ok {
+			log.Printf("[WARN] Machine %q is not in the store anymore, nothing to remove", name)
+			return nil
+		}
 		if err != nil {
-			return fmt.Errorf("Error removing host %q: %s", name, err)
+			// A machine whose configuration cannot be loaded, e.g. because
+			// its driver plugin is not installed anymore, cannot be removed
+			// by its driver either.
+			if !d.Get("force_remove").(bool) || onDestroy == "stop" {
+				return err
+			}
+			log.Printf("[WARN] Error loading machine %q, removing it from the store anyway: %s", name, err)
+			return client.Remove(name)
 		}
+		op.useKeys(client, h)
 
-		exist, err := client.Exists(name)
+		if onDestroy == "stop" {
+			log.Printf("[INFO] Stopping machine %q instead of removing it, it is left in the store", name)
+			return stopMachine(d, h, op)
+		}
+		if err := checkContainers(d, h, op); err != nil {
+			return err
+		}
+		release, err := meta.(*providerConfig).deleteLimit.acquire(h.DriverName, name, op)
 		if err != nil {
-			return fmt.Errorf("Error removing host %q: %s", name, err)
+			return err
+		}
+		err = op.run("driver remove", func() error {
+			return removeMachine(h, op)
+		})
+		if !op.afterAbandoned(release) {
+			release()
 		}
-		if !exist {
-			return fmt.Errorf("Error removing host %q: host does not exist.", name)
+		if err != nil {
+			// A removal still running in the background needs the
+			// machine to stay in the store, whatever force_remove says.
+			if _, ok := err.(*timeoutError); ok {
+				return err
+			}
+			if !d.Get("force_remove").(bool) {
+				return fmt.Errorf("Error removing host %q: %s", name, err)
+			}
+			log.Printf("[WARN] Error removing machine %q with its driver, removing it from the store anyway: %s", name, err)
 		}
 		return client.Remove(name)
 	}
 }
+
+// checkContainers guards the removal of a running machine according to
+// drain_before_destroy, which drains the machine of its containers, and
+// prevent_destroy_if_containers_running, which refuses to remove a machine
+// with running containers, or whose containers cannot be checked.
+func checkContainers(d *schema.ResourceData, h *host.Host, op *timedOperation) error {
+	drain := d.Get("drain_before_destroy").(bool)
+	prevent := d.Get("prevent_destroy_if_containers_running").(bool)
+	if !drain && !prevent {
+		return nil
+	}
+	machineState, err := h.Driver.GetState()
+	if err != nil && !isNotExistError(h.DriverName, err) {
+		// Containers may be running on a machine whose state is unknown.
+		if prevent {
+			return fmt.Errorf("Error removing machine %q: cannot check for running containers: %s", h.Name, err)
+		}
+		log.Printf("[WARN] Cannot drain machine %q, removing it anyway: %s", h.Name, err)
+		return nil
+	}
+	if err != nil || machineState != state.Running {
+		return nil
+	}
+	if drain {
+		if err := op.run("draining containers", func() error { return drainMachine(h) }); err != nil {
+			return fmt.Errorf("Error draining machine %q: %s", h.Name, err)
+		}
+	}
+	if prevent {
+		var containers []dockerclient.Container
+		err := op.run("listing containers", func() error {
+			client, err := mcndockerclient.DockerClient(h)
+			if err != nil {
+				return err
+			}
+			containers, err = client.ListContainers(false, false, "")
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("Error listing containers of machine %q: %s", h.Name, err)
+		}
+		if len(containers) > 0 {
+			return fmt.Errorf("Error removing machine %q: containers are running: %s", h.Name, strings.Join(containerNames(containers), ", "))
+		}
+	}
+	return nil
+}
+
+// drainMachine makes the machine leave the swarm it is a node of, if any, so
+// that its tasks are rescheduled on other nodes, then stops its containers.
+func drainMachine(h *host.Host) error {
+	swarmState, err := h.RunSSHCommand("sudo docker info --format '{{.Swarm.LocalNodeState}}'")
+	if err != nil {
+		return fmt.Errorf("Error reading swarm state: %s", err)
+	}
+	if strings.TrimSpace(swarmState) == "active" {
+		log.Printf("[INFO] Machine %q is leaving the swarm", h.Name)
+		if _, err := h.RunSSHCommand("sudo docker swarm leave --force"); err != nil {
+			return fmt.Errorf("Error leaving swarm: %s", err)
+		}
+	}
+
+	client, err := mcndockerclient.DockerClient(h)
+	if err != nil {
+		return err
+	}
+	containers, err := client.ListContainers(false, false, "")
+	if err != nil {
+		return err
+	}
+	for _, container := range containers {
+		log.Printf("[INFO] Stopping container %s of machine %q", containerName(container), h.Name)
+		if err := client.StopContainer(container.Id, containerStopTimeout); err != nil {
+			return fmt.Errorf("Error stopping container %s: %s", container.Id, err)
+		}
+	}
+	return nil
+}
+
+// containerStopTimeout is the number of seconds containers get to stop before
+// they are killed, as with docker stop.
+const containerStopTimeout = 10
+
+func containerNames(containers []dockerclient.Container) []string {
+	names := make([]string, len(containers))
+	for i, container := range containers {
+		names[i] = containerName(container)
+	}
+	return names
+}
+
+// containerName returns the name of a container, or its ID if it has none.
+func containerName(container dockerclient.Container) string {
+	if len(container.Names) == 0 {
+		return container.Id
+	}
+	return strings.TrimPrefix(container.Names[0], "/")
+}
+
+// stopMachine stops the machine of h, unless it is already stopped.
+func stopMachine(d *schema.ResourceData, h *host.Host, op *timedOperation) error {
+	machineState, err := h.Driver.GetState()
+	if err != nil {
+		return fmt.Errorf("Error attempting to retrieve state: %s", err)
+	}
+	switch machineState {
+	case state.Running, state.Starting:
+		return stopHost(d, h, op)
+	}
+	return nil
+}
+
+// removeMachine removes the machine of h with its driver, retrying with an
+// exponential backoff until the deadline of op, since removal errors of cloud
+// drivers are often transient. Errors telling that the machine does not exist
+// mean that it is already removed.
+func removeMachine(h *host.Host, op *timedOperation) error {
+	return backoff.Retry(func() error {
+		err := h.Driver.Remove()
+		if isNotExistError(h.DriverName, err) {
+			log.Printf("[INFO] Machine %q is already removed: %s", h.Name, err)
+			return nil
+		}
+		if err != nil {
+			log.Printf("[WARN] Error removing machine %q, retrying: %s", h.Name, err)
+		}
+		return err
+	}, op.backoff())
+}
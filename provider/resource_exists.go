@@ -1,23 +1,93 @@
 package provider
 
 import (
-	"github.com/docker/machine/libmachine"
+	"fmt"
+	"log"
+	"regexp"
+
+	"github.com/docker/machine/libmachine/host"
 
 	"github.com/hashicorp/terraform/helper/schema"
 )
 
 func resourceExists(driverName string) func(*schema.ResourceData, interface{}) (bool, error) {
-	return func(d *schema.ResourceData, meta interface{}) (bool, error) {
-		client := meta.(*libmachine.Client)
-		name := d.Get("name").(string)
-		exists, err := client.Exists(name)
+	return func(d *schema.ResourceData, meta interface{}) (exists bool, err error) {
+		client, closeStore, err := openStore(d, meta, nil)
 		if err != nil {
 			return false, err
 		}
-		if !exists {
-			d.SetId(name)
-			return false, nil
-		}
+		defer func() { err = closeStore(err) }()
+		return machineExists(client, d.Get("name").(string))
+	}
+}
+
+// hostStore is the part of machineStore looking machines up.
+type hostStore interface {
+	Exists(name string) (bool, error)
+	Load(name string) (*host.Host, error)
+	withKeys(h *host.Host, fn func() error) error
+}
+
+// machineExists tells whether the named machine is in the store, and still
+// exists according to its driver. Errors of the driver that do not tell that
+// the machine is gone are returned, so that a transient failure does not make
+// Terraform forget the machine.
+func machineExists(store hostStore, name string) (bool, error) {
+	exists, err := store.Exists(name)
+	if err != nil {
+		return false, err
+	}
+	if !exists {
+		log.Printf("[WARN] Machine %q is not in the store anymore", name)
+		return false, nil
+	}
+	h, err := store.Load(name)
+	if err != nil {
+		return false, err
+	}
+	gone, err := machineGone(h)
+	if err != nil {
+		return false, err
+	}
+	if gone {
+		log.Printf("[WARN] Machine %q does not exist anymore according to its driver", name)
+		return false, nil
+	}
+	return true, nil
+}
+
+// notExistErrors match, for each driver, the errors returned when its machine
+// does not exist anymore. Drivers have no common error for this, and the
+// errors of driver plugins only carry their message anyway, so the messages
+// are matched exactly: errors of other drivers, or unrelated errors such as
+// DNS failures, never mean that a machine is gone.
+var notExistErrors = map[string]*regexp.Regexp{
+	"virtualbox":   regexp.MustCompile(`^machine does not exist$`),
+	"amazonec2":    regexp.MustCompile(`(^|: )InvalidInstanceID\.NotFound: `),
+	"digitalocean": regexp.MustCompile(`^(GET|DELETE) https://api\.digitalocean\.com/v2/droplets/\d+: 404 `),
+	"google":       regexp.MustCompile(`^googleapi: Error 404: .*, notFound$`),
+}
+
+// machineGone tells whether the driver of h reports that the machine does not
+// exist anymore, e.g. because it was deleted outside of Terraform. Other
+// errors retrieving its state are returned.
+func machineGone(h *host.Host) (bool, error) {
+	_, err := h.Driver.GetState()
+	if err == nil {
+		return false, nil
+	}
+	if isNotExistError(h.DriverName, err) {
 		return true, nil
 	}
+	return false, fmt.Errorf("Error attempting to retrieve state of machine %q: %s", h.Name, err)
+}
+
+// isNotExistError tells whether err is the error the given driver returns
+// for a machine that does not exist.
+func isNotExistError(driverName string, err error) bool {
+	if err == nil {
+		return false
+	}
+	re, ok := notExistErrors[driverName]
+	return ok && re.MatchString(err.Error())
 }
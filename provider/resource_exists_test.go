@@ -0,0 +1,148 @@
+package provider
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/docker/machine/libmachine/host"
+	"github.com/docker/machine/libmachine/mcnerror"
+	"github.com/docker/machine/libmachine/state"
+)
+
+// stateDriver is a driver whose GetState returns a fixed state or error.
+type stateDriver struct {
+	drivers.Driver
+	state state.State
+	err   error
+}
+
+func (d *stateDriver) GetState() (state.State, error) {
+	return d.state, d.err
+}
+
+// fakeStore is a store of machines in memory.
+type fakeStore map[string]*host.Host
+
+func (s fakeStore) Exists(name string) (bool, error) {
+	_, ok := s[name]
+	return ok, nil
+}
+
+func (s fakeStore) Load(name string) (*host.Host, error) {
+	h, ok := s[name]
+	if !ok {
+		return nil, mcnerror.ErrHostDoesNotExist{Name: name}
+	}
+	return h, nil
+}
+
+func (s fakeStore) withKeys(h *host.Host, fn func() error) error {
+	return fn()
+}
+
+// newFakeStore returns a store holding the machine "test" of the given driver,
+// whose state is machineState or err.
+func newFakeStore(driverName string, machineState state.State, err error) fakeStore {
+	return fakeStore{
+		"test": {
+			Name:       "test",
+			DriverName: driverName,
+			Driver:     &stateDriver{state: machineState, err: err},
+		},
+	}
+}
+
+var machineExistsTests = []struct {
+	name       string
+	store      fakeStore
+	exists     bool
+	shouldFail bool
+}{
+	{
+		name:   "running",
+		store:  newFakeStore("virtualbox", state.Running, nil),
+		exists: true,
+	},
+	{
+		name:   "stopped",
+		store:  newFakeStore("virtualbox", state.Stopped, nil),
+		exists: true,
+	},
+	{
+		name:   "missing from the store",
+		store:  fakeStore{},
+		exists: false,
+	},
+	{
+		name:   "virtualbox machine gone",
+		store:  newFakeStore("virtualbox", state.None, errors.New("machine does not exist")),
+		exists: false,
+	},
+	{
+		name:   "amazonec2 machine gone",
+		store:  newFakeStore("amazonec2", state.None, errors.New("InvalidInstanceID.NotFound: The instance ID 'i-0123456789abcdef0' does not exist\n\tstatus code: 400, request id: 5d4ad2a8")),
+		exists: false,
+	},
+	{
+		name:       "DNS failure",
+		store:      newFakeStore("amazonec2", state.None, errors.New("RequestError: send request failed\ncaused by: Post https://ec2.us-east-1.amazonaws.com/: dial tcp: lookup ec2.us-east-1.amazonaws.com: no such host")),
+		shouldFail: true,
+	},
+	{
+		name:       "error of another driver",
+		store:      newFakeStore("generic", state.None, errors.New("machine does not exist")),
+		shouldFail: true,
+	},
+	{
+		name:       "VBoxManage missing",
+		store:      newFakeStore("virtualbox", state.None, errors.New("VBoxManage not found. Make sure VirtualBox is installed and VBoxManage is in the path")),
+		shouldFail: true,
+	},
+}
+
+func TestMachineExists(t *testing.T) {
+	for _, test := range machineExistsTests {
+		exists, err := machineExists(test.store, "test")
+		if test.shouldFail {
+			if err == nil {
+				t.Errorf("%s: expected an error, got exists=%t", test.name, exists)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+			continue
+		}
+		if exists != test.exists {
+			t.Errorf("%s: expected exists=%t, got %t", test.name, test.exists, exists)
+		}
+	}
+}
+
+func TestIsNotExistError(t *testing.T) {
+	tests := []struct {
+		driverName string
+		err        error
+		notExist   bool
+	}{
+		{"virtualbox", nil, false},
+		{"virtualbox", errors.New("machine does not exist"), true},
+		{"virtualbox", errors.New("host machine does not exist anymore"), false},
+		{"virtualbox", errors.New("VBoxManage not found. Make sure VirtualBox is installed and VBoxManage is in the path"), false},
+		{"amazonec2", errors.New("InvalidInstanceID.NotFound: The instance ID 'i-0123456789abcdef0' does not exist"), true},
+		{"amazonec2", errors.New("InvalidInstanceID.Malformed: Invalid id: \"i-x\""), false},
+		{"amazonec2", errors.New("dial tcp: lookup ec2.us-east-1.amazonaws.com: no such host"), false},
+		{"digitalocean", errors.New("GET https://api.digitalocean.com/v2/droplets/1234: 404 The resource you were accessing could not be found."), true},
+		{"digitalocean", errors.New("GET https://api.digitalocean.com/v2/droplets/1234: 500 Server Error"), false},
+		{"google", errors.New("googleapi: Error 404: The resource 'projects/p/zones/z/instances/test' was not found, notFound"), true},
+		{"google", errors.New("googleapi: Error 403: Access Not Configured, accessNotConfigured"), false},
+		{"generic", errors.New("machine does not exist"), false},
+		{"fake", errors.New("not found"), false},
+	}
+	for _, test := range tests {
+		if notExist := isNotExistError(test.driverName, test.err); notExist != test.notExist {
+			t.Errorf("isNotExistError(%q, %v): expected %t, got %t", test.driverName, test.err, test.notExist, notExist)
+		}
+	}
+}
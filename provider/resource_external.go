@@ -0,0 +1,113 @@
+package provider
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/docker/machine/libmachine/drivers/rpc"
+	"github.com/docker/machine/libmachine/mcnflag"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/helper/validation"
+)
+
+// resourceExternal describes a machine backed by a third-party driver plugin,
+// i.e. a docker-machine-driver-<name> binary found in PATH. Since the create
+// flags of such a driver are only known once the plugin is running, they are
+// passed as a generic map and checked when the machine is created.
+func resourceExternal() *schema.Resource {
+	resourceSchema := commonSchema()
+	resourceSchema["driver"] = &schema.Schema{
+		Type:         schema.TypeString,
+		Required:     true,
+		ForceNew:     true,
+		ValidateFunc: validation.NoZeroValues,
+	}
+	resourceSchema["driver_options"] = &schema.Schema{
+		Type:     schema.TypeMap,
+		Optional: true,
+		ForceNew: true,
+		Elem: &schema.Schema{
+			Type: schema.TypeString,
+		},
+	}
+	return &schema.Resource{
+		Schema:        resourceSchema,
+		Exists:        resourceExists(""),
+		Create:        resourceCreate(""),
+		Read:          resourceRead(""),
+		Update:        resourceUpdate(""),
+		Delete:        resourceDelete(""),
+		CustomizeDiff: resourceCustomizeDiff,
+		Timeouts:      resourceTimeouts(),
+		Importer: &schema.ResourceImporter{
+			State: resourceImport("", nil),
+		},
+	}
+}
+
+// getExternalDriverOpts converts driver_options into driver options, using the
+// create flags reported by the plugin to type each value. Options are matched
+// by flag name, with underscores accepted in place of dashes.
+func getExternalDriverOpts(d *schema.ResourceData, mcnflags []mcnflag.Flag) (drivers.DriverOptions, error) {
+	driverOpts := rpcdriver.RPCFlags{
+		Values: make(map[string]interface{}),
+	}
+
+	options := make(map[string]string)
+	for k, v := range d.Get("driver_options").(map[string]interface{}) {
+		options[strings.Replace(k, "_", "-", -1)] = v.(string)
+	}
+
+	for _, f := range mcnflags {
+		driverOpts.Values[f.String()] = f.Default()
+
+		if f.Default() == nil {
+			driverOpts.Values[f.String()] = false
+		}
+
+		value, ok := options[f.String()]
+		if !ok {
+			continue
+		}
+		delete(options, f.String())
+
+		switch f.(type) {
+		case *mcnflag.StringFlag:
+			driverOpts.Values[f.String()] = value
+		case *mcnflag.StringSliceFlag:
+			driverOpts.Values[f.String()] = ss2is(strings.Split(value, ","))
+		case *mcnflag.IntFlag:
+			i, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("Error parsing driver option %q: %s", f.String(), err)
+			}
+			driverOpts.Values[f.String()] = i
+		case *mcnflag.BoolFlag:
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("Error parsing driver option %q: %s", f.String(), err)
+			}
+			driverOpts.Values[f.String()] = b
+		}
+	}
+
+	if len(options) > 0 {
+		var unknown, valid []string
+		for k := range options {
+			unknown = append(unknown, k)
+		}
+		for _, f := range mcnflags {
+			valid = append(valid, f.String())
+		}
+		sort.Strings(unknown)
+		sort.Strings(valid)
+		return nil, fmt.Errorf("Unknown driver options %s, valid options are: %s",
+			strings.Join(unknown, ", "), strings.Join(valid, ", "))
+	}
+
+	return driverOpts, nil
+}
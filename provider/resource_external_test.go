@@ -0,0 +1,145 @@
+package provider
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/docker/machine/libmachine"
+	"github.com/docker/machine/libmachine/drivers"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+// buildFakeDriver builds the driver plugin of testdata/docker-machine-driver-fake
+// into dir.
+func buildFakeDriver(t *testing.T, dir string) {
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go is required to build the fake driver plugin")
+	}
+	binary := filepath.Join(dir, "docker-machine-driver-fake")
+	if runtime.GOOS == "windows" {
+		binary += ".exe"
+	}
+	out, err := exec.Command(goBin, "build", "-o", binary, "./testdata/docker-machine-driver-fake").CombinedOutput()
+	if err != nil {
+		t.Fatalf("Error building fake driver plugin: %s: %s", err, out)
+	}
+}
+
+func TestGetExternalDriverOpts(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping driver plugin test in short mode")
+	}
+	dir, err := ioutil.TempDir("", "dockermachine-plugins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	buildFakeDriver(t, dir)
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	// The plugin reports its flags once started, as for a new machine.
+	client := libmachine.NewClient(dir, filepath.Join(dir, "certs"))
+	defer client.Close()
+	rawDriver, err := json.Marshal(&drivers.BaseDriver{MachineName: "test", StorePath: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := client.NewHost("fake", rawDriver)
+	if err != nil {
+		t.Fatalf("Error starting the fake driver plugin: %s", err)
+	}
+	flags := h.Driver.GetCreateFlags()
+
+	tests := []struct {
+		name       string
+		options    map[string]interface{}
+		region     string
+		size       int
+		private    bool
+		tags       []string
+		shouldFail string
+	}{
+		{
+			name:    "defaults",
+			options: map[string]interface{}{},
+			region:  "us-east",
+			size:    1,
+			tags:    []string{},
+		},
+		{
+			name: "typed values",
+			options: map[string]interface{}{
+				"fake_region":  "eu-west",
+				"fake_size":    "4",
+				"fake-private": "true",
+				"fake_tag":     "web",
+			},
+			region:  "eu-west",
+			size:    4,
+			private: true,
+			tags:    []string{"web"},
+		},
+		{
+			name:    "comma separated list",
+			options: map[string]interface{}{"fake_tag": "web,db,cache"},
+			region:  "us-east",
+			size:    1,
+			tags:    []string{"web", "db", "cache"},
+		},
+		{
+			name:       "invalid int",
+			options:    map[string]interface{}{"fake_size": "large"},
+			shouldFail: `Error parsing driver option "fake-size"`,
+		},
+		{
+			name:       "invalid bool",
+			options:    map[string]interface{}{"fake_private": "maybe"},
+			shouldFail: `Error parsing driver option "fake-private"`,
+		},
+		{
+			name:       "unknown options",
+			options:    map[string]interface{}{"fake_zone": "a", "region": "eu-west"},
+			shouldFail: "Unknown driver options fake-zone, region, valid options are: fake-private, fake-region, fake-size, fake-tag",
+		},
+	}
+	for _, test := range tests {
+		d := schema.TestResourceDataRaw(t, resourceExternal().Schema, map[string]interface{}{
+			"name":           "test",
+			"driver":         "fake",
+			"driver_options": test.options,
+		})
+		opts, err := getExternalDriverOpts(d, flags)
+		if test.shouldFail != "" {
+			if err == nil || !strings.Contains(err.Error(), test.shouldFail) {
+				t.Errorf("%s: expected error %q, got %v", test.name, test.shouldFail, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+			continue
+		}
+		if region := opts.String("fake-region"); region != test.region {
+			t.Errorf("%s: expected fake-region %q, got %q", test.name, test.region, region)
+		}
+		if size := opts.Int("fake-size"); size != test.size {
+			t.Errorf("%s: expected fake-size %d, got %d", test.name, test.size, size)
+		}
+		if private := opts.Bool("fake-private"); private != test.private {
+			t.Errorf("%s: expected fake-private %t, got %t", test.name, test.private, private)
+		}
+		// Empty lists may come back from the plugin as nil.
+		if tags := opts.StringSlice("fake-tag"); strings.Join(tags, ",") != strings.Join(test.tags, ",") {
+			t.Errorf("%s: expected fake-tag %q, got %q", test.name, test.tags, tags)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/machine/libmachine/host"
+	"github.com/docker/machine/libmachine/mcnflag"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+func resourceImport(driverName string, createFlags []mcnflag.Flag) func(*schema.ResourceData, interface{}) ([]*schema.ResourceData, error) {
+	return func(d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+		config := meta.(*providerConfig)
+		name := d.Id()
+		client, closeStore, err := openBackendStore(config, config.backend, name, true, nil)
+		if err != nil {
+			return nil, err
+		}
+		defer closeStore(nil)
+		// Machines missing from the store backend are imported from
+		// storage_path, and copied into the backend.
+		exists, err := client.Exists(name)
+		if err != nil {
+			return nil, err
+		}
+		if !exists && config.backend != nil {
+			client = newMachineStore(config, config.client, true)
+		}
+		h, err := client.Load(name)
+		if err != nil {
+			return nil, err
+		}
+		if driverName == "" {
+			d.Set("driver", h.DriverName)
+		} else if h.DriverName != driverName {
+			return nil, fmt.Errorf("Error importing machine %q: it uses the %s driver, not %s", name, h.DriverName, driverName)
+		}
+		d.Set("name", name)
+		if err := setHostAttributes(d, client, h); err != nil {
+			return nil, err
+		}
+		if err := setDriverFlags(d, h, createFlags, true); err != nil {
+			return nil, err
+		}
+		if config.storeInState {
+			archive, err := packStore(client.Path, name)
+			if err != nil {
+				return nil, fmt.Errorf("Error saving machine store to state: %s", err)
+			}
+			d.Set("machine_store", archive)
+		} else if !exists && config.backend != nil {
+			if err := config.backend.push(client.Path, name); err != nil {
+				return nil, fmt.Errorf("Error saving machine %q to store: %s", name, err)
+			}
+		}
+		return []*schema.ResourceData{d}, nil
+	}
+}
+
+// setHostAttributes sets the attributes of a machine resource from the stored
+// host options. TLS paths and the storage path are left empty when they match
+// the ones a new machine would get, so that they do not differ from an
+// unset attribute.
+func setHostAttributes(d *schema.ResourceData, client *machineStore, h *host.Host) error {
+	if h.HostOptions == nil || h.HostOptions.AuthOptions == nil {
+		return fmt.Errorf("Error reading machine %q: missing host options", h.Name)
+	}
+	authOptions := h.HostOptions.AuthOptions
+	certsDirectory := filepath.Join(client.Path, "certs")
+	d.Set("certs_directory", authOptions.CertDir)
+	d.Set("tls_ca_cert", nonDefaultPath(authOptions.CaCertPath, filepath.Join(certsDirectory, "ca.pem")))
+	d.Set("tls_ca_key", nonDefaultPath(authOptions.CaPrivateKeyPath, filepath.Join(certsDirectory, "ca-key.pem")))
+	d.Set("tls_client_cert", nonDefaultPath(authOptions.ClientCertPath, filepath.Join(certsDirectory, "cert.pem")))
+	d.Set("tls_client_key", nonDefaultPath(authOptions.ClientKeyPath, filepath.Join(certsDirectory, "key.pem")))
+	d.Set("tls_server_cert", authOptions.ServerCertPath)
+	d.Set("tls_server_key", authOptions.ServerKeyPath)
+	d.Set("storage_path", nonDefaultPath(authOptions.StorePath, filepath.Join(client.Path, "machines", h.Name)))
+	d.Set("storage_path_computed", authOptions.StorePath)
+	d.Set("ssh_username", h.Driver.GetSSHUsername())
+	d.Set("ssh_keypath", h.Driver.GetSSHKeyPath())
+	setHostOptions(d, h.HostOptions)
+	return nil
+}
+
+// setDriverFlags sets the driver flag attributes from the serialized driver
+// configuration. Drivers do not record which flag set which field: flags are
+// matched to fields through driverFlagFields, or else to the field with the
+// same name once the driver prefix, dashes and case are ignored (e.g.
+// "virtualbox-disk-size" and "DiskSize"). Flags without a matching field,
+// such as those of unsupportedDriverFlags, are set to their default value if
+// defaults is set, and left alone otherwise.
+func setDriverFlags(d *schema.ResourceData, h *host.Host, createFlags []mcnflag.Flag, defaults bool) error {
+	var rawDriver map[string]interface{}
+	if err := json.Unmarshal(h.RawDriver, &rawDriver); err != nil {
+		return fmt.Errorf("Error reading driver configuration of machine %q: %s", h.Name, err)
+	}
+
+	for _, flag := range createFlags {
+		attribute := strings.Replace(flag.String(), "-", "_", -1)
+		var value interface{}
+		var found, negated bool
+		if !unsupportedDriverFlags[flag.String()] {
+			var field string
+			field, negated = driverFlagField(h.DriverName, flag.String())
+			value, found = driverField(rawDriver, field)
+		}
+
+		var err error
+		switch f := derefFlag(flag).(type) {
+		case mcnflag.StringFlag:
+			if s, ok := value.(string); found && ok {
+				err = d.Set(attribute, s)
+			} else if s, ok := value.([]interface{}); found && ok {
+				// A list field set from a comma-separated flag.
+				err = d.Set(attribute, strings.Join(is2ss(s), ","))
+			} else if defaults {
+				err = d.Set(attribute, f.Value)
+			}
+		case mcnflag.StringSliceFlag:
+			if s, ok := value.([]interface{}); found && ok {
+				err = d.Set(attribute, s)
+			} else if defaults {
+				err = d.Set(attribute, f.Value)
+			}
+		case mcnflag.IntFlag:
+			if n, ok := value.(float64); found && ok {
+				err = d.Set(attribute, int(n))
+			} else if defaults {
+				err = d.Set(attribute, f.Value)
+			}
+		case mcnflag.BoolFlag:
+			if b, ok := value.(bool); found && ok {
+				err = d.Set(attribute, b != negated)
+			} else if defaults {
+				err = d.Set(attribute, false)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("Error setting %s: %s", attribute, err)
+		}
+	}
+	return nil
+}
+
+// driverField returns the value of the field at path, whose components are
+// separated by dots to reach the fields of nested structs, ignoring case.
+func driverField(fields map[string]interface{}, path string) (interface{}, bool) {
+	var value interface{} = fields
+	for _, name := range strings.Split(path, ".") {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		found := false
+		for k, v := range m {
+			if strings.EqualFold(k, name) {
+				value, found = v, true
+				break
+			}
+		}
+		if !found {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// driverFlagFields maps the flags of each driver whose field is not named
+// after them to that field. A "!" prefix denotes a boolean field holding the
+// negation of its flag.
+var driverFlagFields = map[string]map[string]string{
+	"amazonec2": {
+		"amazonec2-insecure-transport":   "DisableSSL",
+		"amazonec2-keypair-name":         "KeyName",
+		"amazonec2-open-port":            "OpenPorts",
+		"amazonec2-private-address-only": "PrivateIPOnly",
+		"amazonec2-retries":              "RetryCount",
+		"amazonec2-security-group":       "SecurityGroupNames",
+		"amazonec2-ssh-keypath":          "SSHPrivateKeyPath",
+		"amazonec2-use-private-address":  "UsePrivateIP",
+		"amazonec2-userdata":             "UserDataFile",
+	},
+	"azure": {
+		"azure-custom-data":        "CustomDataFile",
+		"azure-dns":                "DNSLabel",
+		"azure-open-port":          "OpenPorts",
+		"azure-private-ip-address": "PrivateIPAddr",
+		"azure-subnet":             "SubnetName",
+		"azure-vnet":               "VirtualNetwork",
+	},
+	"digitalocean": {
+		"digitalocean-ssh-key-path": "SSHKey",
+		"digitalocean-userdata":     "UserDataFile",
+	},
+	"exoscale": {
+		"exoscale-affinity-group": "AffinityGroups",
+		"exoscale-security-group": "SecurityGroups",
+		"exoscale-userdata":       "UserDataFile",
+	},
+	"generic": {
+		"generic-ip-address": "IPAddress",
+	},
+	"google": {
+		"google-open-port": "OpenPorts",
+		"google-username":  "SSHUser",
+	},
+	"hyperv": {
+		"hyperv-cpu-count":         "CPU",
+		"hyperv-memory":            "MemSize",
+		"hyperv-static-macaddress": "MacAddr",
+		"hyperv-virtual-switch":    "VSwitch",
+	},
+	"openstack": {
+		"openstack-net-id":       "NetworkId",
+		"openstack-net-name":     "NetworkName",
+		"openstack-nova-network": "ComputeNetwork",
+		"openstack-sec-groups":   "SecurityGroups",
+	},
+	"softlayer": {
+		"softlayer-api-endpoint": "Client.Endpoint",
+		"softlayer-api-key":      "Client.ApiKey",
+		"softlayer-user":         "Client.User",
+	},
+	"virtualbox": {
+		"virtualbox-cpu-count":             "CPU",
+		"virtualbox-hostonly-nicpromisc":   "HostOnlyPromiscMode",
+		"virtualbox-import-boot2docker-vm": "Boot2DockerImportVM",
+		"virtualbox-no-dns-proxy":          "!DNSProxy",
+	},
+	"vmwarefusion": {
+		"vmwarefusion-cpu-count":   "CPU",
+		"vmwarefusion-memory-size": "Memory",
+	},
+	"vmwarevcloudair": {
+		"vmwarevcloudair-orgvdcnetwork": "OrgVDCNet",
+		"vmwarevcloudair-password":      "UserPassword",
+	},
+	"vmwarevsphere": {
+		"vmwarevsphere-cfgparam":     "CfgParams",
+		"vmwarevsphere-cpu-count":    "CPU",
+		"vmwarevsphere-memory-size":  "Memory",
+		"vmwarevsphere-network":      "Networks",
+		"vmwarevsphere-vcenter":      "IP",
+		"vmwarevsphere-vcenter-port": "Port",
+	},
+}
+
+// unsupportedDriverFlags lists the flags of the built-in drivers whose value
+// is not kept in the driver configuration, or only in a form it cannot be
+// read back from: the softlayer driver keeps the machine specification in an
+// unexported field, and the openstack driver keeps the contents of the user
+// data file rather than its path.
+var unsupportedDriverFlags = map[string]bool{
+	"openstack-user-data-file":   true,
+	"rackspace-docker-install":   true,
+	"softlayer-cpu":              true,
+	"softlayer-disk-size":        true,
+	"softlayer-domain":           true,
+	"softlayer-hostname":         true,
+	"softlayer-hourly-billing":   true,
+	"softlayer-image":            true,
+	"softlayer-local-disk":       true,
+	"softlayer-memory":           true,
+	"softlayer-private-net-only": true,
+	"softlayer-private-vlan-id":  true,
+	"softlayer-public-vlan-id":   true,
+	"softlayer-region":           true,
+}
+
+// driverFlagField returns the driver field set by a flag, and whether it holds
+// the negation of the flag.
+func driverFlagField(driverName, flag string) (string, bool) {
+	if field, ok := driverFlagFields[driverName][flag]; ok {
+		return strings.TrimPrefix(field, "!"), strings.HasPrefix(field, "!")
+	}
+	return strings.Replace(strings.TrimPrefix(flag, driverName+"-"), "-", "", -1), false
+}
+
+func nonDefaultPath(path, defaultPath string) string {
+	if filepath.Clean(path) == filepath.Clean(defaultPath) {
+		return ""
+	}
+	return path
+}
@@ -0,0 +1,140 @@
+package provider
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/docker/machine/libmachine/drivers/plugin/localbinary"
+	"github.com/docker/machine/libmachine/host"
+	"github.com/docker/machine/libmachine/mcnflag"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+// TestDriverFlagFields checks that every flag of the built-in drivers is read
+// back from a field of their configuration on import, unless it is listed as
+// unsupported.
+func TestDriverFlagFields(t *testing.T) {
+	for _, driverName := range localbinary.CoreDrivers {
+		driver := getDriver(driverName, "test", "/tmp/store")
+		data, err := json.Marshal(driver)
+		if err != nil {
+			t.Fatalf("%s: %s", driverName, err)
+		}
+		var rawDriver map[string]interface{}
+		if err := json.Unmarshal(data, &rawDriver); err != nil {
+			t.Fatalf("%s: %s", driverName, err)
+		}
+		for _, flag := range driver.GetCreateFlags() {
+			if unsupportedDriverFlags[flag.String()] {
+				continue
+			}
+			field, _ := driverFlagField(driverName, flag.String())
+			if _, found := driverField(rawDriver, field); !found {
+				t.Errorf("%s: flag %s has no field %s in the driver configuration", driverName, flag.String(), field)
+			}
+		}
+	}
+}
+
+var setDriverFlagsTests = []struct {
+	name       string
+	driverName string
+	rawDriver  string
+	flags      []mcnflag.Flag
+	config     map[string]interface{}
+	defaults   bool
+	expected   map[string]interface{}
+}{
+	{
+		name:       "fields named after flags or mapped",
+		driverName: "virtualbox",
+		rawDriver:  `{"CPU": 2, "DiskSize": 40000, "DNSProxy": true, "HostOnlyCIDR": "192.168.99.1/24"}`,
+		flags: []mcnflag.Flag{
+			mcnflag.IntFlag{Name: "virtualbox-cpu-count", Value: 1},
+			mcnflag.IntFlag{Name: "virtualbox-disk-size", Value: 20000},
+			mcnflag.BoolFlag{Name: "virtualbox-no-dns-proxy"},
+			mcnflag.StringFlag{Name: "virtualbox-hostonly-cidr", Value: "192.168.99.1/24"},
+		},
+		defaults: true,
+		expected: map[string]interface{}{
+			"virtualbox_cpu_count":     2,
+			"virtualbox_disk_size":     40000,
+			"virtualbox_no_dns_proxy":  false,
+			"virtualbox_hostonly_cidr": "192.168.99.1/24",
+		},
+	},
+	{
+		name:       "list field of a comma-separated flag",
+		driverName: "openstack",
+		rawDriver:  `{"SecurityGroups": ["default", "docker"]}`,
+		flags: []mcnflag.Flag{
+			mcnflag.StringFlag{Name: "openstack-sec-groups"},
+		},
+		expected: map[string]interface{}{
+			"openstack_sec_groups": "default,docker",
+		},
+	},
+	{
+		name:       "nested field",
+		driverName: "softlayer",
+		rawDriver:  `{"Client": {"User": "admin", "ApiKey": "key"}}`,
+		flags: []mcnflag.Flag{
+			mcnflag.StringFlag{Name: "softlayer-user"},
+			mcnflag.StringFlag{Name: "softlayer-api-key"},
+		},
+		expected: map[string]interface{}{
+			"softlayer_user":    "admin",
+			"softlayer_api_key": "key",
+		},
+	},
+	{
+		name:       "unsupported flag on import",
+		driverName: "softlayer",
+		rawDriver:  `{"Client": {}}`,
+		flags: []mcnflag.Flag{
+			mcnflag.IntFlag{Name: "softlayer-cpu", Value: 1},
+		},
+		config:   map[string]interface{}{"softlayer_cpu": 4},
+		defaults: true,
+		expected: map[string]interface{}{
+			"softlayer_cpu": 1,
+		},
+	},
+	{
+		name:       "unsupported flag on adoption",
+		driverName: "softlayer",
+		rawDriver:  `{"Client": {}}`,
+		flags: []mcnflag.Flag{
+			mcnflag.IntFlag{Name: "softlayer-cpu", Value: 1},
+		},
+		config: map[string]interface{}{"softlayer_cpu": 4},
+		expected: map[string]interface{}{
+			"softlayer_cpu": 4,
+		},
+	},
+}
+
+func TestSetDriverFlags(t *testing.T) {
+	for _, test := range setDriverFlagsTests {
+		config := map[string]interface{}{"name": "test"}
+		for k, v := range test.config {
+			config[k] = v
+		}
+		d := schema.TestResourceDataRaw(t, resource(test.driverName, test.flags).Schema, config)
+		h := &host.Host{
+			Name:       "test",
+			DriverName: test.driverName,
+			RawDriver:  []byte(test.rawDriver),
+		}
+		if err := setDriverFlags(d, h, test.flags, test.defaults); err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+			continue
+		}
+		for attribute, expected := range test.expected {
+			if value := d.Get(attribute); value != expected {
+				t.Errorf("%s: expected %s to be %v, got %v", test.name, attribute, expected, value)
+			}
+		}
+	}
+}
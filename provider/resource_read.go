@@ -1,62 +1,177 @@
 package provider
 
 import (
+	"crypto/x509"
+	"encoding/pem"
 	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
 	"strings"
+	"time"
 
-	"github.com/docker/machine/libmachine"
+	"github.com/docker/machine/libmachine/host"
+	"github.com/docker/machine/libmachine/mcnerror"
 	"github.com/docker/machine/libmachine/state"
 
 	"github.com/hashicorp/terraform/helper/schema"
 )
 
 func resourceRead(driverName string) func(*schema.ResourceData, interface{}) error {
-	return func(d *schema.ResourceData, meta interface{}) error {
-		client := meta.(*libmachine.Client)
-		name := d.Get("name").(string)
-		var err error
-		h, err := client.Load(name)
+	return func(d *schema.ResourceData, meta interface{}) (err error) {
+		client, closeStore, err := openStore(d, meta, nil)
 		if err != nil {
 			return err
 		}
-		machineState, err := h.Driver.GetState()
-		if err != nil {
-			return fmt.Errorf("Error attempting to retrieve state: %s", err)
-		}
-		if machineState == state.Running {
-			sshHostname, err := h.Driver.GetSSHHostname()
-			if err != nil {
-				return fmt.Errorf("Error attempting to retrieve ssh hostname: %s", err)
-			}
-			d.Set("ssh_hostname", sshHostname)
-			sshPort, err := h.Driver.GetSSHPort()
-			if err != nil {
-				return fmt.Errorf("Error attempting to retrieve ssh port: %s", err)
-			}
-			d.Set("ssh_port", sshPort)
-			address, err := h.Driver.GetIP()
-			if err != nil {
-				return fmt.Errorf("Error attempting to retrieve address: %s", err)
-			}
-			d.Set("address", address)
-			dockerUrl, err := h.Driver.GetURL()
-			if err != nil {
-				return fmt.Errorf("Error attempting to retrieve docker url: %s", err)
-			}
-			d.Set("docker_url", dockerUrl)
-			dockerVersion, err := h.DockerVersion()
-			if err != nil {
-				return fmt.Errorf("Error attempting to retrieve docker version: %s", err)
-			}
-			d.Set("docker_version", dockerVersion)
-		} else {
-			d.Set("ssh_hostname", nil)
-			d.Set("ssh_port", nil)
-			d.Set("address", nil)
-			d.Set("docker_url", nil)
-			d.Set("docker_version", nil)
-		}
-		d.Set("state", strings.ToLower(machineState.String()))
+		defer func() { err = closeStore(err) }()
+		return readResource(d, client, d.Get("name").(string))
+	}
+}
+
+// readResource refreshes the resource of the named machine. A machine missing
+// from the store, or gone according to its driver, is removed from the state.
+func readResource(d *schema.ResourceData, store hostStore, name string) error {
+	h, err := store.Load(name)
+	if _, ok := err.(mcnerror.ErrHostDoesNotExist); ok {
+		log.Printf("[WARN] Machine %q is not in the store anymore, removing it from the state", name)
+		d.SetId("")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	gone, err := machineGone(h)
+	if err != nil {
+		return err
+	}
+	if gone {
+		log.Printf("[WARN] Machine %q does not exist anymore according to its driver, removing it from the state", name)
+		d.SetId("")
+		return nil
+	}
+	return store.withKeys(h, func() error {
+		if err := readTLSMaterial(d, h); err != nil {
+			return err
+		}
+		return readMachine(d, h)
+	})
+}
+
+// readTLSMaterial sets the PEM attributes from the certificates and keys
+// used by the machine. Files that do not exist leave their attribute empty.
+func readTLSMaterial(d *schema.ResourceData, h *host.Host) error {
+	authOptions := h.AuthOptions()
+	if authOptions == nil {
 		return nil
 	}
+	files := map[string]string{
+		"ca_cert_pem":     authOptions.CaCertPath,
+		"client_cert_pem": authOptions.ClientCertPath,
+		"client_key_pem":  authOptions.ClientKeyPath,
+		"server_cert_pem": authOptions.ServerCertPath,
+		"server_key_pem":  authOptions.ServerKeyPath,
+	}
+	for attribute, path := range files {
+		data, err := ioutil.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("Error reading %s: %s", path, err)
+		}
+		d.Set(attribute, string(data))
+	}
+	return readCertExpiry(d)
+}
+
+// readCertExpiry sets the expiry time of the server and client certificates,
+// and whether the server certificate is due for renewal according to
+// cert_renew_before.
+func readCertExpiry(d *schema.ResourceData) error {
+	serverNotAfter, err := certNotAfter(d.Get("server_cert_pem").(string))
+	if err != nil {
+		return fmt.Errorf("Error reading server certificate: %s", err)
+	}
+	clientNotAfter, err := certNotAfter(d.Get("client_cert_pem").(string))
+	if err != nil {
+		return fmt.Errorf("Error reading client certificate: %s", err)
+	}
+	d.Set("server_cert_not_after", formatTime(serverNotAfter))
+	d.Set("client_cert_not_after", formatTime(clientNotAfter))
+
+	renewalRequired := false
+	if renewBefore := d.Get("cert_renew_before").(string); renewBefore != "" && !serverNotAfter.IsZero() {
+		window, err := time.ParseDuration(renewBefore)
+		if err != nil {
+			return fmt.Errorf("Error parsing cert_renew_before: %s", err)
+		}
+		renewalRequired = time.Now().Add(window).After(serverNotAfter)
+	}
+	d.Set("cert_renewal_required", renewalRequired)
+	return nil
+}
+
+// certNotAfter returns the end of the validity period of a PEM encoded
+// certificate, or the zero time if there is no certificate.
+func certNotAfter(certPEM string) (time.Time, error) {
+	if certPEM == "" {
+		return time.Time{}, nil
+	}
+	block, _ := pem.Decode([]byte(certPEM))
+	if block == nil {
+		return time.Time{}, fmt.Errorf("no PEM data found")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return cert.NotAfter, nil
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// readMachine sets the state of the machine, along with the attributes that
+// can only be retrieved while it is running.
+func readMachine(d *schema.ResourceData, h *host.Host) error {
+	machineState, err := h.Driver.GetState()
+	if err != nil {
+		return fmt.Errorf("Error attempting to retrieve state: %s", err)
+	}
+	if machineState == state.Running {
+		sshHostname, err := h.Driver.GetSSHHostname()
+		if err != nil {
+			return fmt.Errorf("Error attempting to retrieve ssh hostname: %s", err)
+		}
+		d.Set("ssh_hostname", sshHostname)
+		sshPort, err := h.Driver.GetSSHPort()
+		if err != nil {
+			return fmt.Errorf("Error attempting to retrieve ssh port: %s", err)
+		}
+		d.Set("ssh_port", sshPort)
+		address, err := h.Driver.GetIP()
+		if err != nil {
+			return fmt.Errorf("Error attempting to retrieve address: %s", err)
+		}
+		d.Set("address", address)
+		dockerUrl, err := h.Driver.GetURL()
+		if err != nil {
+			return fmt.Errorf("Error attempting to retrieve docker url: %s", err)
+		}
+		d.Set("docker_url", dockerUrl)
+		dockerVersion, err := h.DockerVersion()
+		if err != nil {
+			return fmt.Errorf("Error attempting to retrieve docker version: %s", err)
+		}
+		d.Set("docker_version", dockerVersion)
+	} else {
+		d.Set("ssh_hostname", nil)
+		d.Set("ssh_port", nil)
+		d.Set("address", nil)
+		d.Set("docker_url", nil)
+		d.Set("docker_version", nil)
+	}
+	d.Set("state", strings.ToLower(machineState.String()))
+	return nil
 }
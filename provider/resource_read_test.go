@@ -0,0 +1,82 @@
+package provider
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/docker/machine/libmachine/state"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+func TestReadResourceRemovesGoneMachines(t *testing.T) {
+	tests := []struct {
+		name  string
+		store fakeStore
+	}{
+		{"missing from the store", fakeStore{}},
+		{"virtualbox machine gone", newFakeStore("virtualbox", state.None, errors.New("machine does not exist"))},
+		{"google machine gone", newFakeStore("google", state.None, errors.New("googleapi: Error 404: The resource 'projects/p/zones/z/instances/test' was not found, notFound"))},
+	}
+	for _, test := range tests {
+		d := schema.TestResourceDataRaw(t, commonSchema(), map[string]interface{}{"name": "test"})
+		d.SetId("test")
+		if err := readResource(d, test.store, "test"); err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+			continue
+		}
+		if d.Id() != "" {
+			t.Errorf("%s: expected the machine to be removed from the state", test.name)
+		}
+	}
+}
+
+func TestReadResourceKeepsMachinesOnErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		store fakeStore
+	}{
+		{"DNS failure", newFakeStore("digitalocean", state.None, errors.New("Get https://api.digitalocean.com/v2/droplets/1234: dial tcp: lookup api.digitalocean.com: no such host"))},
+		{"error of another driver", newFakeStore("generic", state.None, errors.New("machine does not exist"))},
+	}
+	for _, test := range tests {
+		d := schema.TestResourceDataRaw(t, commonSchema(), map[string]interface{}{"name": "test"})
+		d.SetId("test")
+		if err := readResource(d, test.store, "test"); err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+		if d.Id() != "test" {
+			t.Errorf("%s: expected the machine to stay in the state", test.name)
+		}
+	}
+}
+
+func TestReadResourceKeepsStoppedMachines(t *testing.T) {
+	tests := []struct {
+		name         string
+		machineState state.State
+		expected     string
+	}{
+		{"stopped", state.Stopped, "stopped"},
+		{"saved", state.Saved, "saved"},
+		{"paused", state.Paused, "paused"},
+	}
+	for _, test := range tests {
+		d := schema.TestResourceDataRaw(t, commonSchema(), map[string]interface{}{"name": "test"})
+		d.SetId("test")
+		d.Set("address", "192.168.99.100")
+		if err := readResource(d, newFakeStore("virtualbox", test.machineState, nil), "test"); err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+			continue
+		}
+		if d.Id() != "test" {
+			t.Errorf("%s: expected the machine to stay in the state", test.name)
+		}
+		if machineState := d.Get("state").(string); machineState != test.expected {
+			t.Errorf("%s: expected state %q, got %q", test.name, test.expected, machineState)
+		}
+		if address := d.Get("address").(string); address != "" {
+			t.Errorf("%s: expected the address to be cleared, got %q", test.name, address)
+		}
+	}
+}
@@ -2,83 +2,154 @@ package provider
 
 import (
 	"fmt"
-	"strings"
 
-	"github.com/docker/machine/libmachine"
+	"github.com/docker/machine/libmachine/host"
 	"github.com/docker/machine/libmachine/state"
 
 	"github.com/hashicorp/terraform/helper/schema"
 )
 
 func resourceUpdate(driverName string) func(*schema.ResourceData, interface{}) error {
-	return func(d *schema.ResourceData, meta interface{}) error {
-		client := meta.(*libmachine.Client)
+	return func(d *schema.ResourceData, meta interface{}) (err error) {
 		name := d.Get("name").(string)
-		var err error
+		op := newTimedOperation(fmt.Sprintf("updating machine %q", name), d.Timeout(schema.TimeoutUpdate))
+		client, closeStore, err := openStore(d, meta, op)
+		if err != nil {
+			return err
+		}
+		defer func() { err = closeStore(err) }()
 		h, err := client.Load(name)
 		if err != nil {
 			return err
 		}
+		op.useKeys(client, h)
 		if d.HasChange("state") {
 			machineState, err := h.Driver.GetState()
 			if err != nil {
 				return fmt.Errorf("Error attempting to retrieve state: %s", err)
 			}
-			switch d.Get("state").(string) {
-			case "running":
-				switch machineState {
-				case state.Paused, state.Saved, state.Stopped, state.Stopping:
-					if err = h.Start(); err != nil {
-						return fmt.Errorf("Error while attempting to start machine: %s", err)
-					}
-				}
-			case "stopped":
-				switch machineState {
-				case state.Running, state.Starting:
-					if err = h.Stop(); err != nil {
-						return fmt.Errorf("Error while attempting to stop machine: %s", err)
-					}
+			if err := setMachineState(d, h, machineState, op); err != nil {
+				return err
+			}
+			if d.Get("state").(string) == "running" {
+				if err := waitForReady(d, h, op); err != nil {
+					return err
 				}
 			}
-			machineState, err = h.Driver.GetState()
-			if err != nil {
-				return fmt.Errorf("Error attempting to retrieve state: %s", err)
+			if err := client.withKeys(h, func() error { return readMachine(d, h) }); err != nil {
+				return err
 			}
-			if machineState == state.Running {
-				sshHostname, err := h.Driver.GetSSHHostname()
-				if err != nil {
-					return fmt.Errorf("Error attempting to retrieve ssh hostname: %s", err)
-				}
-				d.Set("ssh_hostname", sshHostname)
-				sshPort, err := h.Driver.GetSSHPort()
-				if err != nil {
-					return fmt.Errorf("Error attempting to retrieve ssh port: %s", err)
-				}
-				d.Set("ssh_port", sshPort)
-				address, err := h.Driver.GetIP()
-				if err != nil {
-					return fmt.Errorf("Error attempting to retrieve address: %s", err)
-				}
-				d.Set("address", address)
-				dockerUrl, err := h.Driver.GetURL()
-				if err != nil {
-					return fmt.Errorf("Error attempting to retrieve docker url: %s", err)
-				}
-				d.Set("docker_url", dockerUrl)
-				dockerVersion, err := h.DockerVersion()
-				if err != nil {
-					return fmt.Errorf("Error attempting to retrieve docker version: %s", err)
-				}
-				d.Set("docker_version", dockerVersion)
-			} else {
-				d.Set("ssh_hostname", nil)
-				d.Set("ssh_port", nil)
-				d.Set("address", nil)
-				d.Set("docker_url", nil)
-				d.Set("docker_version", nil)
+		}
+		if d.HasChange("restart_trigger") && !d.HasChange("state") && d.Get("state").(string) == "running" {
+			if err := op.run("restarting the machine", h.Restart); err != nil {
+				revertChanges(d, "restart_trigger")
+				return fmt.Errorf("Error while attempting to restart machine: %s", err)
+			}
+			if err := waitForReady(d, h, op); err != nil {
+				return err
+			}
+			if err := client.withKeys(h, func() error { return readMachine(d, h) }); err != nil {
+				return err
+			}
+		}
+		if hasChange(d, "tls_san", "regenerate_certs", "cert_renewal_required") {
+			if err := regenerateCerts(d, h, op); err != nil {
+				revertChanges(d, "tls_san", "regenerate_certs", "cert_renewal_required")
+				return err
+			}
+			if err := client.Save(h); err != nil {
+				return fmt.Errorf("Error attempting to save store: %s", err)
+			}
+			if err := client.withKeys(h, func() error { return readTLSMaterial(d, h) }); err != nil {
+				return err
+			}
+		}
+		if hasChange(d, engineAttributes...) {
+			if err := updateEngine(d, h, op); err != nil {
+				revertChanges(d, engineAttributes...)
+				return err
+			}
+			if err := client.Save(h); err != nil {
+				return fmt.Errorf("Error attempting to save store: %s", err)
+			}
+			if err := client.withKeys(h, func() error { return readTLSMaterial(d, h) }); err != nil {
+				return err
 			}
-			d.Set("state", strings.ToLower(machineState.String()))
 		}
 		return nil
 	}
 }
+
+// engineAttributes are the engine options that can be changed in place.
+var engineAttributes = []string{
+	"engine_opt",
+	"engine_env",
+	"engine_insecure_registry",
+	"engine_label",
+	"engine_registry_mirror",
+	"engine_storage_driver",
+}
+
+// updateEngine sets the engine options of the host from the resource, and
+// runs the provisioner again to apply them. Provisioning is not limited to the
+// engine configuration: it also generates and copies a new server certificate,
+// and configures swarm again, before restarting the daemon.
+func updateEngine(d *schema.ResourceData, h *host.Host, op *timedOperation) error {
+	machineState, err := h.Driver.GetState()
+	if err != nil {
+		return fmt.Errorf("Error attempting to retrieve state: %s", err)
+	}
+	if machineState != state.Running {
+		return fmt.Errorf("Error reconfiguring engine: machine %q is not running", h.Name)
+	}
+	engineOptions := h.HostOptions.EngineOptions
+	engineOptions.ArbitraryFlags = is2ss(d.Get("engine_opt").([]interface{}))
+	engineOptions.Env = is2ss(d.Get("engine_env").([]interface{}))
+	engineOptions.InsecureRegistry = is2ss(d.Get("engine_insecure_registry").([]interface{}))
+	engineOptions.Labels = is2ss(d.Get("engine_label").([]interface{}))
+	engineOptions.RegistryMirror = is2ss(d.Get("engine_registry_mirror").([]interface{}))
+	engineOptions.StorageDriver = d.Get("engine_storage_driver").(string)
+	if err := op.run("provisioning", h.Provision); err != nil {
+		return fmt.Errorf("Error reconfiguring engine: %s", err)
+	}
+	return nil
+}
+
+// regenerateCerts generates a new server certificate for the machine, signed
+// by its CA and valid for the configured SANs, then copies it to the machine
+// and restarts the docker daemon.
+func regenerateCerts(d *schema.ResourceData, h *host.Host, op *timedOperation) error {
+	machineState, err := h.Driver.GetState()
+	if err != nil {
+		return fmt.Errorf("Error attempting to retrieve state: %s", err)
+	}
+	if machineState != state.Running {
+		return fmt.Errorf("Error regenerating certificates: machine %q is not running", h.Name)
+	}
+	authOptions := h.HostOptions.AuthOptions
+	authOptions.ServerCertSANs = is2ss(d.Get("tls_san").([]interface{}))
+	if err := op.run("certificate copy", h.ConfigureAuth); err != nil {
+		return fmt.Errorf("Error regenerating certificates: %s", err)
+	}
+	d.Set("tls_server_cert", authOptions.ServerCertPath)
+	d.Set("tls_server_key", authOptions.ServerKeyPath)
+	return nil
+}
+
+func hasChange(d *schema.ResourceData, keys ...string) bool {
+	for _, key := range keys {
+		if d.HasChange(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// revertChanges sets back the previous value of the given attributes, so that
+// a failed update is attempted again on the next apply.
+func revertChanges(d *schema.ResourceData, keys ...string) {
+	for _, key := range keys {
+		old, _ := d.GetChange(key)
+		d.Set(key, old)
+	}
+}
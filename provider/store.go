@@ -0,0 +1,443 @@
+package provider
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/machine/libmachine"
+	"github.com/docker/machine/libmachine/host"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+// storeRootPlaceholder replaces the store root in the archived config.json
+// files, whose paths are absolute, so that the store can be rebuilt anywhere.
+const storeRootPlaceholder = "{{storage_path}}"
+
+// providerConfig is the meta value of the provider.
+type providerConfig struct {
+	client       *libmachine.Client
+	backend      storeBackend
+	storeInState bool
+	encryption   *storeEncryption
+	lockTimeout  time.Duration
+	createLimit  *concurrencyLimit
+	deleteLimit  *concurrencyLimit
+}
+
+// storeBackend keeps machine stores away from storage_path. Since drivers and
+// provisioners need the store files on the local disk, machines are copied
+// from the backend into a temporary store before each operation, and saved
+// back afterwards.
+type storeBackend interface {
+	// pull copies the named machine, if the backend has it, and the
+	// certificates into the store under root.
+	pull(root, name string) error
+	// push saves the named machine from the store under root, or removes it
+	// from the backend if it is not there anymore. Certificates are saved
+	// unless the backend already has some, so that machines created
+	// concurrently cannot replace the CA of each other.
+	push(root, name string) error
+	// shareCerts saves the certificates of the store under root if the
+	// backend has none yet, or replaces them with those of the backend
+	// otherwise, so that machines created concurrently, which both found
+	// no certificates to pull, end up with the same CA.
+	shareCerts(root, name string) error
+	// list returns the names of the machines in the backend.
+	list() ([]string, error)
+}
+
+// machineStore is the client an operation on machines works with. When the
+// store under storage_path is encrypted, the calls needing the private keys of
+// a machine are made through withKeys, and machines are saved with the paths
+// of their keys in the store.
+type machineStore struct {
+	*libmachine.Client
+	keys *keyring
+}
+
+// newMachineStore returns the machine store over client. Keys created or
+// replaced by the operation are only saved into an encrypted store unless
+// readOnly is set.
+func newMachineStore(config *providerConfig, client *libmachine.Client, readOnly bool) *machineStore {
+	store := &machineStore{Client: client}
+	if config.encryption != nil {
+		store.keys = &keyring{
+			encryption: config.encryption,
+			readOnly:   readOnly,
+			hosts:      make(map[*host.Host]*hostKeys),
+		}
+	}
+	return store
+}
+
+// withKeys calls fn with the private keys of h decrypted, if the store is
+// encrypted.
+func (s *machineStore) withKeys(h *host.Host, fn func() error) error {
+	if s.keys == nil {
+		return fn()
+	}
+	return s.keys.withKeys(h, fn)
+}
+
+func (s *machineStore) Save(h *host.Host) error {
+	if s.keys == nil {
+		return s.Client.Save(h)
+	}
+	return s.keys.save(h, func() error {
+		return s.Client.Save(h)
+	})
+}
+
+// openStore returns the client an operation on a machine resource works with,
+// and a function to call with the result of the operation once it is done.
+// Calls abandoned by op keep the store open until they return.
+func openStore(d *schema.ResourceData, meta interface{}, op *timedOperation) (*machineStore, func(error) error, error) {
+	config := meta.(*providerConfig)
+	backend := config.backend
+	if config.storeInState {
+		backend = &stateBackend{d: d}
+	}
+	return openBackendStore(config, backend, d.Get("name").(string), false, op)
+}
+
+// openBackendStore returns a client over a temporary store holding the named
+// machine, copied from backend, or over storage_path if there is no backend.
+// Unless readOnly is set, the function returned along with the client saves
+// the machine back into the backend; it always removes the temporary store.
+// If op abandoned calls, which may still change the machine, the machine is
+// saved again, and the temporary store removed, once they return.
+func openBackendStore(config *providerConfig, backend storeBackend, name string, readOnly bool, op *timedOperation) (*machineStore, func(error) error, error) {
+	if backend == nil {
+		return openFilesystemStore(config, name, readOnly, op)
+	}
+
+	root, err := ioutil.TempDir("", "terraform-provider-dockermachine")
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error creating temporary store: %s", err)
+	}
+	if err := backend.pull(root, name); err != nil {
+		os.RemoveAll(root)
+		return nil, nil, fmt.Errorf("Error retrieving machine %q from store: %s", name, err)
+	}
+	client := libmachine.NewClient(root, filepath.Join(root, "certs"))
+	removeStore := func() {
+		client.Close()
+		os.RemoveAll(root)
+	}
+
+	return newMachineStore(config, client, readOnly), func(err error) error {
+		if readOnly {
+			if !op.afterAbandoned(removeStore) {
+				removeStore()
+			}
+			return err
+		}
+		pushErr := backend.push(root, name)
+		abandoned := op.afterAbandoned(func() {
+			// The resource data cannot be changed anymore once the
+			// operation has returned.
+			if _, ok := backend.(*stateBackend); !ok {
+				if err := backend.push(root, name); err != nil {
+					log.Printf("[ERROR] Error saving machine %q to store after its abandoned calls returned: %s", name, err)
+				}
+			}
+			removeStore()
+		})
+		if !abandoned {
+			removeStore()
+		}
+		if pushErr != nil {
+			pushErr = fmt.Errorf("Error saving machine %q to store: %s", name, pushErr)
+			if err != nil {
+				log.Printf("[ERROR] %s", pushErr)
+				return err
+			}
+			return pushErr
+		}
+		return err
+	}, nil
+}
+
+// openFilesystemStore returns the client over storage_path, holding the lock
+// of the named machine until the returned function is called, or until the
+// calls abandoned by op return. Readers, which set readOnly, take no lock, so
+// that data sources never wait for, nor fail because of, the operations of
+// other processes.
+func openFilesystemStore(config *providerConfig, name string, readOnly bool, op *timedOperation) (*machineStore, func(error) error, error) {
+	if readOnly {
+		return newMachineStore(config, config.client, true), func(err error) error {
+			return err
+		}, nil
+	}
+	lock, err := acquireLock(config.client.Path, machineLockName(name), config.lockTimeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	closeStore := lock.release
+	return newMachineStore(config, config.client, readOnly), func(err error) error {
+		abandoned := op.afterAbandoned(func() {
+			if closeErr := closeStore(); closeErr != nil {
+				log.Printf("[ERROR] %s", closeErr)
+			}
+		})
+		if abandoned {
+			return err
+		}
+		if closeErr := closeStore(); closeErr != nil {
+			if err != nil {
+				log.Printf("[ERROR] %s", closeErr)
+				return err
+			}
+			return closeErr
+		}
+		return err
+	}, nil
+}
+
+// stateBackend keeps the store of a machine in the machine_store attribute of
+// its resource.
+type stateBackend struct {
+	d *schema.ResourceData
+}
+
+func (b *stateBackend) pull(root, name string) error {
+	return unpackStore(root, b.d.Get("machine_store").(string))
+}
+
+func (b *stateBackend) push(root, name string) error {
+	if b.d.Id() == "" {
+		b.d.Set("machine_store", "")
+		return nil
+	}
+	archive, err := packStore(root, name)
+	if err != nil {
+		return err
+	}
+	b.d.Set("machine_store", archive)
+	return nil
+}
+
+// shareCerts does nothing, since each machine kept in the state has its own
+// certificates.
+func (b *stateBackend) shareCerts(root, name string) error {
+	return nil
+}
+
+func (b *stateBackend) list() ([]string, error) {
+	return nil, fmt.Errorf("machines kept in the state cannot be listed")
+}
+
+// packStore archives the files of the named machine and the certificates
+// from the store under root, as a base64 string.
+func packStore(root, name string) (string, error) {
+	files, err := readStoreFiles(root, name)
+	if err != nil {
+		return "", err
+	}
+	data, err := encodeStoreFiles(files)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+// unpackStore extracts an archive made by packStore under root.
+func unpackStore(root, archive string) error {
+	if archive == "" {
+		return nil
+	}
+	data, err := base64.StdEncoding.DecodeString(archive)
+	if err != nil {
+		return err
+	}
+	files, err := decodeStoreFiles(data)
+	if err != nil {
+		return err
+	}
+	return writeStoreFiles(root, files)
+}
+
+// storeFile is a file of a store, keyed by its slash separated path relative
+// to the store root.
+type storeFile struct {
+	mode os.FileMode
+	data []byte
+}
+
+func machinePrefix(name string) string {
+	return path.Join("machines", name) + "/"
+}
+
+const certsPrefix = "certs/"
+
+// readStoreFiles reads the configuration, SSH keys and certificates of the
+// named machine, and the certificates directory, from the store under root.
+// Other files, such as disk images, are left out. The store root is replaced
+// by a placeholder in config.json.
+func readStoreFiles(root, name string) (map[string]storeFile, error) {
+	placeholder, err := jsonRoot(root)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]storeFile)
+	for _, dir := range []string{machinePrefix(name), certsPrefix} {
+		infos, err := ioutil.ReadDir(filepath.Join(root, filepath.FromSlash(dir)))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range infos {
+			if !info.Mode().IsRegular() || !isStoreFile(info.Name()) {
+				continue
+			}
+			data, err := ioutil.ReadFile(filepath.Join(root, filepath.FromSlash(dir), info.Name()))
+			if err != nil {
+				return nil, err
+			}
+			if info.Name() == "config.json" {
+				data = bytes.Replace(data, []byte(placeholder), []byte(storeRootPlaceholder), -1)
+			}
+			files[dir+info.Name()] = storeFile{
+				mode: info.Mode().Perm(),
+				data: data,
+			}
+		}
+	}
+	return files, nil
+}
+
+// writeStoreFiles writes files into the store under root, replacing the
+// placeholder of config.json with root.
+func writeStoreFiles(root string, files map[string]storeFile) error {
+	placeholder, err := jsonRoot(root)
+	if err != nil {
+		return err
+	}
+	for name, file := range files {
+		cleaned := path.Clean(name)
+		if strings.HasPrefix(cleaned, "../") || path.IsAbs(cleaned) {
+			return fmt.Errorf("invalid path %q in store", name)
+		}
+		data := file.data
+		if path.Base(cleaned) == "config.json" {
+			data = bytes.Replace(data, []byte(storeRootPlaceholder), []byte(placeholder), -1)
+		}
+		filename := filepath.Join(root, filepath.FromSlash(cleaned))
+		if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filename, data, file.mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// filterStoreFiles returns the files whose path starts with one of prefixes.
+func filterStoreFiles(files map[string]storeFile, prefixes ...string) map[string]storeFile {
+	filtered := make(map[string]storeFile)
+	for name, file := range files {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(name, prefix) {
+				filtered[name] = file
+				break
+			}
+		}
+	}
+	return filtered
+}
+
+// encodeStoreFiles archives files as a gzipped tarball. Files are archived in
+// the order of their names, and without timestamps, so that an unchanged store
+// gives the same archive, and machine_store no diff.
+func encodeStoreFiles(files map[string]storeFile) ([]byte, error) {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, name := range names {
+		file := files[name]
+		header := &tar.Header{
+			Name: name,
+			Mode: int64(file.mode),
+			Size: int64(len(file.data)),
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(file.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeStoreFiles extracts files from an archive made by encodeStoreFiles.
+func decodeStoreFiles(data []byte) (map[string]storeFile, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(gz)
+	files := make(map[string]storeFile)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		files[header.Name] = storeFile{
+			mode: os.FileMode(header.Mode).Perm(),
+			data: content,
+		}
+	}
+}
+
+// isStoreFile tells whether a file of a machine directory, or of the
+// certificates directory, is needed to manage the machine.
+func isStoreFile(name string) bool {
+	return name == "config.json" || strings.HasPrefix(name, "id_rsa") || strings.HasSuffix(name, ".pem")
+}
+
+// jsonRoot returns root as it appears in a JSON document.
+func jsonRoot(root string) (string, error) {
+	data, err := json.Marshal(root)
+	if err != nil {
+		return "", err
+	}
+	return strings.Trim(string(data), `"`), nil
+}
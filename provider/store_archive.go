@@ -0,0 +1,161 @@
+package provider
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// archiveBackend keeps all the machines and the certificates in a single
+// encrypted file. Its read-modify-write cycles hold a lock file next to it,
+// so that they do not overwrite the changes of each other, from this or other
+// processes.
+type archiveBackend struct {
+	path        string
+	cipher      *passphraseCipher
+	lockTimeout time.Duration
+}
+
+func newArchiveBackend(path, passphrase string, lockTimeout time.Duration) (*archiveBackend, error) {
+	cipher, err := newPassphraseCipher(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return &archiveBackend{
+		path:        path,
+		cipher:      cipher,
+		lockTimeout: lockTimeout,
+	}, nil
+}
+
+func (b *archiveBackend) pull(root, name string) error {
+	files, err := b.read()
+	if err != nil {
+		return err
+	}
+	return writeStoreFiles(root, filterStoreFiles(files, machinePrefix(name), certsPrefix))
+}
+
+func (b *archiveBackend) push(root, name string) error {
+	lock, err := acquireLockFile(b.path+".lock", b.lockTimeout)
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+	files, err := b.read()
+	if err != nil {
+		return err
+	}
+	local, err := readStoreFiles(root, name)
+	if err != nil {
+		return err
+	}
+
+	hasCerts := len(filterStoreFiles(files, certsPrefix)) > 0
+	for file := range filterStoreFiles(files, machinePrefix(name)) {
+		delete(files, file)
+	}
+	for file, content := range local {
+		if strings.HasPrefix(file, certsPrefix) && hasCerts {
+			continue
+		}
+		files[file] = content
+	}
+	return b.write(files)
+}
+
+func (b *archiveBackend) shareCerts(root, name string) error {
+	lock, err := acquireLockFile(b.path+".lock", b.lockTimeout)
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+	files, err := b.read()
+	if err != nil {
+		return err
+	}
+	if certs := filterStoreFiles(files, certsPrefix); len(certs) > 0 {
+		return writeStoreFiles(root, certs)
+	}
+	local, err := readStoreFiles(root, name)
+	if err != nil {
+		return err
+	}
+	certs := filterStoreFiles(local, certsPrefix)
+	if len(certs) == 0 {
+		return nil
+	}
+	for file, content := range certs {
+		files[file] = content
+	}
+	return b.write(files)
+}
+
+func (b *archiveBackend) list() ([]string, error) {
+	files, err := b.read()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for file := range files {
+		if dir, base := path.Split(file); base == "config.json" && strings.HasPrefix(dir, "machines/") {
+			names = append(names, path.Base(dir))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// read returns the files of the archive, which is empty if it does not exist.
+func (b *archiveBackend) read() (map[string]storeFile, error) {
+	data, err := ioutil.ReadFile(b.path)
+	if os.IsNotExist(err) {
+		return make(map[string]storeFile), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, err = b.cipher.decrypt(data)
+	if err != nil {
+		return nil, err
+	}
+	return decodeStoreFiles(data)
+}
+
+// write replaces the archive, through a rename so that it is never left
+// partially written.
+func (b *archiveBackend) write(files map[string]storeFile) error {
+	data, err := encodeStoreFiles(files)
+	if err != nil {
+		return err
+	}
+	data, err = b.cipher.encrypt(data)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(b.path), 0700); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(b.path), filepath.Base(b.path))
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), b.path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
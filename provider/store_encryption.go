@@ -0,0 +1,421 @@
+package provider
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/docker/machine/libmachine/host"
+)
+
+// storeEncryption encrypts the private keys of the store under storage_path
+// at rest. Keys are never decrypted in the store: the calls needing them get
+// them decrypted into a temporary directory of their own, see decryptKeys, so
+// that an interrupted operation does not leave them in plaintext there.
+type storeEncryption struct {
+	cipher *passphraseCipher
+	// root is the store under storage_path.
+	root string
+}
+
+func newStoreEncryption(key, root string) (*storeEncryption, error) {
+	cipher, err := newPassphraseCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return &storeEncryption{
+		cipher: cipher,
+		root:   root,
+	}, nil
+}
+
+// migrate encrypts the private keys left in plaintext in the store, e.g. by
+// a store created before encryption was enabled, and those encrypted by former
+// versions of the provider without key derivation: those of the certificates
+// directory, and those of the machines no other operation is using. The keys
+// of the latter are encrypted by that operation once it saves the machine.
+func (e *storeEncryption) migrate(lockTimeout time.Duration) error {
+	lock, err := acquireLock(e.root, certsLockName, lockTimeout)
+	if err != nil {
+		return err
+	}
+	err = e.encryptDir(filepath.Join(e.root, "certs"))
+	lock.release()
+	if err != nil {
+		return err
+	}
+
+	machines, err := ioutil.ReadDir(filepath.Join(e.root, "machines"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Error listing machines to encrypt: %s", err)
+	}
+	for _, machine := range machines {
+		if !machine.IsDir() {
+			continue
+		}
+		lock, err := acquireLock(e.root, machineLockName(machine.Name()), 0)
+		if err != nil {
+			log.Printf("[DEBUG] Not encrypting the keys of machine %q, which is in use: %s", machine.Name(), err)
+			continue
+		}
+		err = e.encryptDir(filepath.Join(e.root, "machines", machine.Name()))
+		lock.release()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *storeEncryption) encryptDir(dir string) error {
+	err := transformKeys(dir, func(data []byte) ([]byte, error) {
+		if isLegacyEncrypted(data) {
+			plaintext, err := e.cipher.decrypt(data)
+			if err != nil {
+				return nil, err
+			}
+			data = plaintext
+		} else if isEncrypted(data) {
+			return nil, nil
+		}
+		return e.cipher.encrypt(data)
+	})
+	if err != nil {
+		return fmt.Errorf("Error encrypting %s: %s", dir, err)
+	}
+	return nil
+}
+
+// hostKeys are the private keys of a host, decrypted into a temporary
+// directory which the host points to instead of the store.
+type hostKeys struct {
+	dir  string
+	keys []*decryptedKey
+	// users counts the calls using the keys, which are only encrypted
+	// back once the last one returns.
+	users int
+}
+
+// decryptedKey is a key of hostKeys.
+type decryptedKey struct {
+	// storePath is the path of the key in the store, and path the path of
+	// the decrypted key.
+	storePath string
+	path      string
+	// encrypted tells whether the key is kept encrypted in the store:
+	// those under storage_path are, while those elsewhere, e.g. the CA key
+	// of dockermachine_certificates, are kept as they were found.
+	encrypted bool
+	// stored is the plaintext of the key as currently found in the store,
+	// or nil if the store has to be updated.
+	stored []byte
+	// use points the host at path, if set.
+	use func(path string) error
+}
+
+// decryptKeys decrypts the SSH key and the TLS keys of h into a new 0700
+// temporary directory, and points h at them.
+func (e *storeEncryption) decryptKeys(h *host.Host) (*hostKeys, error) {
+	dir, err := ioutil.TempDir("", "terraform-provider-dockermachine-keys")
+	if err != nil {
+		return nil, fmt.Errorf("Error creating temporary keys directory: %s", err)
+	}
+	k := &hostKeys{dir: dir}
+	if authOptions := h.AuthOptions(); authOptions != nil {
+		k.add("ca-key.pem", authOptions.CaPrivateKeyPath, func(path string) error {
+			authOptions.CaPrivateKeyPath = path
+			return nil
+		})
+		k.add("key.pem", authOptions.ClientKeyPath, func(path string) error {
+			authOptions.ClientKeyPath = path
+			return nil
+		})
+		k.add("server-key.pem", authOptions.ServerKeyPath, func(path string) error {
+			authOptions.ServerKeyPath = path
+			return nil
+		})
+	}
+	if sshKeyPath := h.Driver.GetSSHKeyPath(); sshKeyPath != "" {
+		k.add("id_rsa", sshKeyPath, func(path string) error {
+			return setSSHKeyPath(h.Driver, path)
+		})
+		// The public key is generated along with the private one, and
+		// is not encrypted.
+		k.add("id_rsa.pub", sshKeyPath+".pub", nil)
+	}
+
+	for _, key := range k.keys {
+		key.encrypted = key.use != nil && isWithin(e.root, key.storePath)
+		data, err := ioutil.ReadFile(key.storePath)
+		if os.IsNotExist(err) {
+			continue
+		}
+		wasEncrypted := err == nil && isEncrypted(data)
+		legacy := err == nil && isLegacyEncrypted(data)
+		if wasEncrypted {
+			data, err = e.cipher.decrypt(data)
+		}
+		if err == nil {
+			err = ioutil.WriteFile(key.path, data, 0600)
+		}
+		if err != nil {
+			os.RemoveAll(dir)
+			return nil, fmt.Errorf("Error decrypting %s: %s", key.storePath, err)
+		}
+		// Keys left in plaintext where they are kept encrypted, or
+		// encrypted by former versions, are encrypted by the next sync.
+		key.encrypted = key.encrypted || wasEncrypted
+		if (wasEncrypted && !legacy) || !key.encrypted {
+			key.stored = data
+		}
+	}
+	if err := k.point(true); err != nil {
+		k.point(false)
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return k, nil
+}
+
+func (k *hostKeys) add(name, storePath string, use func(string) error) {
+	if storePath == "" {
+		return
+	}
+	k.keys = append(k.keys, &decryptedKey{
+		storePath: storePath,
+		path:      filepath.Join(k.dir, name),
+		use:       use,
+	})
+}
+
+// point points the host at the decrypted keys, or back at the store.
+func (k *hostKeys) point(decrypted bool) error {
+	for _, key := range k.keys {
+		if key.use == nil {
+			continue
+		}
+		path := key.storePath
+		if decrypted {
+			path = key.path
+		}
+		if err := key.use(path); err != nil {
+			return fmt.Errorf("Error setting key path of machine: %s", err)
+		}
+	}
+	return nil
+}
+
+// sync saves into the store the keys created or replaced in the temporary
+// directory, encrypting those kept encrypted.
+func (k *hostKeys) sync(e *storeEncryption) error {
+	for _, key := range k.keys {
+		data, err := ioutil.ReadFile(key.path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if key.stored != nil && bytes.Equal(data, key.stored) {
+			continue
+		}
+		stored := data
+		if key.encrypted {
+			if stored, err = e.cipher.encrypt(data); err != nil {
+				return err
+			}
+		}
+		if err := os.MkdirAll(filepath.Dir(key.storePath), 0700); err != nil {
+			return err
+		}
+		if err := replaceFile(key.storePath, stored, 0600); err != nil {
+			return fmt.Errorf("Error saving %s: %s", key.storePath, err)
+		}
+		key.stored = data
+	}
+	return nil
+}
+
+// close points the host back at the store, and removes the decrypted keys.
+func (k *hostKeys) close() error {
+	err := k.point(false)
+	if removeErr := os.RemoveAll(k.dir); err == nil {
+		err = removeErr
+	}
+	return err
+}
+
+// setSSHKeyPath changes the SSH key path of a driver. Driver plugins only
+// expose their configuration as a whole, as JSON.
+func setSSHKeyPath(driver drivers.Driver, path string) error {
+	data, err := json.Marshal(driver)
+	if err != nil {
+		return err
+	}
+	var config map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&config); err != nil {
+		return err
+	}
+	config["SSHKeyPath"] = path
+	if data, err = json.Marshal(config); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, driver)
+}
+
+// isWithin tells whether path lies under dir.
+func isWithin(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// keyring tracks the hosts whose keys are decrypted, for a store.
+type keyring struct {
+	encryption *storeEncryption
+	// readOnly stores do not save keys back.
+	readOnly bool
+	hosts    map[*host.Host]*hostKeys
+	mutex    sync.Mutex
+}
+
+// withKeys calls fn with the keys of h decrypted, see decryptKeys. Once the
+// last call using them returns, the keys fn created or replaced are saved
+// into the store, h is pointed back at the store, and the temporary
+// directory is removed.
+func (r *keyring) withKeys(h *host.Host, fn func() error) error {
+	r.mutex.Lock()
+	k := r.hosts[h]
+	if k == nil {
+		var err error
+		if k, err = r.encryption.decryptKeys(h); err != nil {
+			r.mutex.Unlock()
+			return err
+		}
+		r.hosts[h] = k
+	}
+	k.users++
+	r.mutex.Unlock()
+
+	err := fn()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	k.users--
+	if k.users > 0 {
+		return err
+	}
+	delete(r.hosts, h)
+	var closeErr error
+	if !r.readOnly {
+		closeErr = k.sync(r.encryption)
+	}
+	if cerr := k.close(); closeErr == nil {
+		closeErr = cerr
+	}
+	if closeErr != nil {
+		closeErr = fmt.Errorf("Error encrypting keys of machine %q: %s", h.Name, closeErr)
+		if err != nil {
+			log.Printf("[ERROR] %s", closeErr)
+			return err
+		}
+		return closeErr
+	}
+	return err
+}
+
+// save calls fn, which saves h, with h pointed at the store, after saving
+// the keys of h into it, if they are currently decrypted.
+func (r *keyring) save(h *host.Host, fn func() error) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	k := r.hosts[h]
+	if k == nil {
+		return fn()
+	}
+	if err := k.sync(r.encryption); err != nil {
+		return fmt.Errorf("Error encrypting keys of machine %q: %s", h.Name, err)
+	}
+	if err := k.point(false); err != nil {
+		return err
+	}
+	err := fn()
+	if pointErr := k.point(true); err == nil {
+		err = pointErr
+	}
+	return err
+}
+
+// transformKeys replaces each private key of dir with the result of fn, unless
+// it returns nil. Keys are replaced atomically, since other processes may read
+// the shared certificates meanwhile.
+func transformKeys(dir string, fn func([]byte) ([]byte, error)) error {
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if !file.Mode().IsRegular() || !isPrivateKeyFile(file.Name()) {
+			continue
+		}
+		path := filepath.Join(dir, file.Name())
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		data, err = fn(data)
+		if err != nil {
+			return fmt.Errorf("%s: %s", file.Name(), err)
+		}
+		if data == nil {
+			continue
+		}
+		if err := replaceFile(path, data, file.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replaceFile atomically replaces the file at path with data.
+func replaceFile(path string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), perm)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// isPrivateKeyFile tells whether a file of the store is an SSH or TLS
+// private key: id_rsa, ca-key.pem, key.pem or server-key.pem.
+func isPrivateKeyFile(name string) bool {
+	return name == "id_rsa" || strings.HasSuffix(name, "key.pem")
+}
@@ -0,0 +1,257 @@
+package provider
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// httpBackend keeps the machines and the certificates in a key/value HTTP
+// service, with one gzipped tarball per machine and one for the certificates:
+//
+//	GET    <url>/machines/<name>  returns the machine with its ETag, or 404
+//	PUT    <url>/machines/<name>  stores the machine, and returns its ETag
+//	DELETE <url>/machines/<name>  removes the machine
+//	GET    <url>/machines         returns the JSON array of machine names
+//	GET    <url>/certs            returns the certificates, or 404
+//	PUT    <url>/certs            stores the certificates
+//
+// Writes are conditional, and answered with 412 when the condition fails:
+// machines are stored and removed with an "If-Match" header holding the ETag
+// they were pulled with, or with "If-None-Match: *" if they did not exist, so
+// that an operation does not overwrite the changes of another one, and
+// certificates are stored with "If-None-Match: *".
+type httpBackend struct {
+	url    string
+	token  string
+	client *http.Client
+	// etags holds the ETag of the machine pulled into each store root,
+	// or "" if it did not exist.
+	etags map[string]string
+	mutex sync.Mutex
+}
+
+func newHTTPBackend(address, token string) (*httpBackend, error) {
+	if _, err := url.Parse(address); err != nil {
+		return nil, err
+	}
+	return &httpBackend{
+		url:   strings.TrimRight(address, "/"),
+		token: token,
+		client: &http.Client{
+			Timeout: time.Minute,
+		},
+		etags: make(map[string]string),
+	}, nil
+}
+
+func (b *httpBackend) pull(root, name string) error {
+	for _, key := range []string{"machines/" + url.PathEscape(name), "certs"} {
+		data, etag, err := b.getWithETag(key)
+		if err != nil {
+			return err
+		}
+		if key != "certs" {
+			b.setETag(root, etag)
+		}
+		if data == nil {
+			continue
+		}
+		files, err := decodeStoreFiles(data)
+		if err != nil {
+			return err
+		}
+		if err := writeStoreFiles(root, files); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *httpBackend) push(root, name string) error {
+	files, err := readStoreFiles(root, name)
+	if err != nil {
+		return err
+	}
+
+	key := "machines/" + url.PathEscape(name)
+	etag := b.etag(root)
+	var header http.Header
+	if etag != "" {
+		header = http.Header{"If-Match": {etag}}
+	} else {
+		header = http.Header{"If-None-Match": {"*"}}
+	}
+	if machine := filterStoreFiles(files, machinePrefix(name)); len(machine) > 0 {
+		data, err := encodeStoreFiles(machine)
+		if err != nil {
+			return err
+		}
+		resp, err := b.send("PUT", key, data, header, http.StatusOK, http.StatusCreated, http.StatusNoContent)
+		if err != nil {
+			return conflictError(name, err)
+		}
+		b.setETag(root, resp.Header.Get("ETag"))
+	} else if etag != "" {
+		if _, err := b.send("DELETE", key, nil, header, http.StatusOK, http.StatusNoContent, http.StatusNotFound); err != nil {
+			return conflictError(name, err)
+		}
+		b.setETag(root, "")
+	}
+
+	if certs := filterStoreFiles(files, certsPrefix); len(certs) > 0 {
+		if _, err := b.putFilesIfAbsent("certs", certs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *httpBackend) shareCerts(root, name string) error {
+	files, err := readStoreFiles(root, name)
+	if err != nil {
+		return err
+	}
+	if certs := filterStoreFiles(files, certsPrefix); len(certs) > 0 {
+		stored, err := b.putFilesIfAbsent("certs", certs)
+		if err != nil || stored {
+			return err
+		}
+	}
+	data, err := b.get("certs")
+	if err != nil || data == nil {
+		return err
+	}
+	certs, err := decodeStoreFiles(data)
+	if err != nil {
+		return err
+	}
+	return writeStoreFiles(root, certs)
+}
+
+func (b *httpBackend) list() ([]string, error) {
+	data, err := b.get("machines")
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	if data == nil {
+		return names, nil
+	}
+	if err := json.Unmarshal(data, &names); err != nil {
+		return nil, fmt.Errorf("Error decoding machine list: %s", err)
+	}
+	return names, nil
+}
+
+func (b *httpBackend) etag(root string) string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.etags[root]
+}
+
+func (b *httpBackend) setETag(root, etag string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.etags[root] = etag
+}
+
+// errPreconditionFailed is returned by send when the service answers 412 to a
+// conditional request.
+var errPreconditionFailed = fmt.Errorf("precondition failed")
+
+// conflictError explains the failure of a conditional write of the named
+// machine.
+func conflictError(name string, err error) error {
+	if err == errPreconditionFailed {
+		return fmt.Errorf("machine %q was changed in the store by another operation since it was read, refresh and try again", name)
+	}
+	return err
+}
+
+// putFilesIfAbsent stores files under key unless the backend has a value for
+// it already, and tells whether they were stored.
+func (b *httpBackend) putFilesIfAbsent(key string, files map[string]storeFile) (bool, error) {
+	data, err := encodeStoreFiles(files)
+	if err != nil {
+		return false, err
+	}
+	_, err = b.send("PUT", key, data, http.Header{"If-None-Match": {"*"}}, http.StatusOK, http.StatusCreated, http.StatusNoContent)
+	if err == errPreconditionFailed {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// get returns the value of key, or nil if there is none.
+func (b *httpBackend) get(key string) ([]byte, error) {
+	data, _, err := b.getWithETag(key)
+	return data, err
+}
+
+// getWithETag returns the value of key and its ETag, or nil if there is none.
+func (b *httpBackend) getWithETag(key string) ([]byte, string, error) {
+	req, err := b.request("GET", key, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, "", nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("GET %s: unexpected status %s", req.URL, resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, resp.Header.Get("ETag"), nil
+}
+
+// send sends a request with the given headers, and returns its response if
+// its status is one of expected, or errPreconditionFailed on a 412.
+func (b *httpBackend) send(method, key string, body []byte, header http.Header, expected ...int) (*http.Response, error) {
+	req, err := b.request(method, key, body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	for _, status := range expected {
+		if resp.StatusCode == status {
+			return resp, nil
+		}
+	}
+	if resp.StatusCode == http.StatusPreconditionFailed {
+		return nil, errPreconditionFailed
+	}
+	return nil, fmt.Errorf("%s %s: unexpected status %s", method, req.URL, resp.Status)
+}
+
+func (b *httpBackend) request(method, key string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequest(method, b.url+"/"+key, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if b.token != "" {
+		req.Header.Set("Authorization", "Bearer "+b.token)
+	}
+	return req, nil
+}
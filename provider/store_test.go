@@ -0,0 +1,367 @@
+package provider
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+// tempStore creates a store holding the machine "test", with a disk image that
+// is not part of the machine files, and certificates.
+func tempStore(t *testing.T) string {
+	root, err := ioutil.TempDir("", "dockermachine-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	placeholder, err := jsonRoot(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"machines/test/config.json": `{"StorePath": "` + placeholder + `"}`,
+		"machines/test/id_rsa":      "ssh key",
+		"machines/test/disk.vmdk":   "disk",
+		"certs/ca.pem":              "ca",
+		"certs/key.pem":             "client key",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "dockermachine-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// checkStoreFiles checks that the store under root holds the machine files
+// and certificates of tempStore, with root in config.json.
+func checkStoreFiles(t *testing.T, name, root string) {
+	placeholder, err := jsonRoot(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"machines/test/config.json": `{"StorePath": "` + placeholder + `"}`,
+		"machines/test/id_rsa":      "ssh key",
+		"certs/ca.pem":              "ca",
+		"certs/key.pem":             "client key",
+	}
+	for file, content := range expected {
+		data, err := ioutil.ReadFile(filepath.Join(root, filepath.FromSlash(file)))
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		if string(data) != content {
+			t.Errorf("%s: expected %s to be %q, got %q", name, file, content, data)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "machines", "test", "disk.vmdk")); !os.IsNotExist(err) {
+		t.Errorf("%s: expected the disk image to be left out, got %v", name, err)
+	}
+}
+
+func TestPackStore(t *testing.T) {
+	root := tempStore(t)
+	defer os.RemoveAll(root)
+	archive, err := packStore(root, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unpacked := tempDir(t)
+	defer os.RemoveAll(unpacked)
+	if err := unpackStore(unpacked, archive); err != nil {
+		t.Fatal(err)
+	}
+	checkStoreFiles(t, "unpacked store", unpacked)
+}
+
+func TestPackStoreDeterministic(t *testing.T) {
+	root := tempStore(t)
+	defer os.RemoveAll(root)
+	first, err := packStore(root, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Files get new modification times, and maps are iterated in a
+	// random order: several packings must still agree.
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(root, "certs", "ca.pem"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		archive, err := packStore(root, "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if archive != first {
+			t.Fatalf("expected packing an unchanged store to give the same archive")
+		}
+	}
+}
+
+var writeStoreFilesTests = []struct {
+	name       string
+	shouldFail bool
+}{
+	{"machines/test/config.json", false},
+	{"machines/test/../other/config.json", false},
+	{"../config.json", true},
+	{"machines/../../config.json", true},
+	{"/etc/config.json", true},
+}
+
+func TestWriteStoreFiles(t *testing.T) {
+	for _, test := range writeStoreFilesTests {
+		root := tempDir(t)
+		err := writeStoreFiles(root, map[string]storeFile{
+			test.name: {mode: 0600, data: []byte("{}")},
+		})
+		if test.shouldFail && err == nil {
+			t.Errorf("%s: expected the path to be rejected", test.name)
+		}
+		if !test.shouldFail && err != nil {
+			t.Errorf("%s: unexpected error: %s", test.name, err)
+		}
+		os.RemoveAll(root)
+	}
+}
+
+// fakeHTTPStore is an HTTP service implementing the protocol of httpBackend.
+type fakeHTTPStore struct {
+	values map[string][]byte
+	etags  map[string]string
+	serial int
+	mutex  sync.Mutex
+}
+
+func newFakeHTTPStore() *httptest.Server {
+	store := &fakeHTTPStore{
+		values: make(map[string][]byte),
+		etags:  make(map[string]string),
+	}
+	return httptest.NewServer(store)
+}
+
+func (s *fakeHTTPStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	key := r.URL.Path
+	if r.Method == "GET" && key == "/machines" {
+		names := []string{}
+		for k := range s.values {
+			if strings.HasPrefix(k, "/machines/") {
+				names = append(names, strings.TrimPrefix(k, "/machines/"))
+			}
+		}
+		sort.Strings(names)
+		json.NewEncoder(w).Encode(names)
+		return
+	}
+
+	value, exists := s.values[key]
+	if match := r.Header.Get("If-Match"); match != "" && (!exists || match != s.etags[key]) {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+	if r.Header.Get("If-None-Match") == "*" && exists {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("ETag", s.etags[key])
+		w.Write(value)
+	case "PUT":
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.serial++
+		s.values[key] = data
+		s.etags[key] = strconv.Quote(strconv.Itoa(s.serial))
+		w.Header().Set("ETag", s.etags[key])
+		w.WriteHeader(http.StatusNoContent)
+	case "DELETE":
+		delete(s.values, key)
+		delete(s.etags, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+var storeBackendTests = []struct {
+	name string
+	// backend returns the backend to test, and the function releasing it.
+	backend func(t *testing.T) (storeBackend, func())
+}{
+	{
+		name: "archive",
+		backend: func(t *testing.T) (storeBackend, func()) {
+			dir := tempDir(t)
+			backend, err := newArchiveBackend(filepath.Join(dir, "machines.tar.gz.enc"), "secret", time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return backend, func() { os.RemoveAll(dir) }
+		},
+	},
+	{
+		name: "http",
+		backend: func(t *testing.T) (storeBackend, func()) {
+			server := newFakeHTTPStore()
+			backend, err := newHTTPBackend(server.URL, "token")
+			if err != nil {
+				t.Fatal(err)
+			}
+			return backend, server.Close
+		},
+	},
+	{
+		name: "state",
+		backend: func(t *testing.T) (storeBackend, func()) {
+			d := schema.TestResourceDataRaw(t, commonSchema(), map[string]interface{}{"name": "test"})
+			d.SetId("test")
+			return &stateBackend{d: d}, func() {}
+		},
+	},
+}
+
+func TestStoreBackends(t *testing.T) {
+	for _, test := range storeBackendTests {
+		backend, release := test.backend(t)
+		root := tempStore(t)
+		if err := backend.pull(root, "test"); err != nil {
+			t.Errorf("%s: error pulling a missing machine: %s", test.name, err)
+		}
+		if err := backend.push(root, "test"); err != nil {
+			t.Errorf("%s: error pushing: %s", test.name, err)
+		}
+
+		pulled := tempDir(t)
+		if err := backend.pull(pulled, "test"); err != nil {
+			t.Errorf("%s: error pulling: %s", test.name, err)
+		}
+		checkStoreFiles(t, test.name, pulled)
+
+		if _, ok := backend.(*stateBackend); !ok {
+			names, err := backend.list()
+			if err != nil || len(names) != 1 || names[0] != "test" {
+				t.Errorf("%s: expected the machine to be listed, got %q (%v)", test.name, names, err)
+			}
+		}
+
+		// Removing the machine from the store removes it from the
+		// backend.
+		if err := os.RemoveAll(filepath.Join(pulled, "machines")); err != nil {
+			t.Fatal(err)
+		}
+		if err := backend.push(pulled, "test"); err != nil {
+			t.Errorf("%s: error pushing a removed machine: %s", test.name, err)
+		}
+		removed := tempDir(t)
+		if err := backend.pull(removed, "test"); err != nil {
+			t.Errorf("%s: error pulling a removed machine: %s", test.name, err)
+		}
+		if _, err := os.Stat(filepath.Join(removed, "machines", "test")); !os.IsNotExist(err) {
+			t.Errorf("%s: expected the machine to be removed, got %v", test.name, err)
+		}
+
+		for _, dir := range []string{root, pulled, removed} {
+			os.RemoveAll(dir)
+		}
+		release()
+	}
+}
+
+func TestArchiveBackendWrongPassphrase(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "machines.tar.gz.enc")
+	backend, err := newArchiveBackend(path, "secret", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := tempStore(t)
+	defer os.RemoveAll(root)
+	if err := backend.push(root, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	wrong, err := newArchiveBackend(path, "wrong", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pulled := tempDir(t)
+	defer os.RemoveAll(pulled)
+	if err := wrong.pull(pulled, "test"); err == nil || !strings.Contains(err.Error(), "check the encryption key") {
+		t.Errorf("expected a decryption error, got %v", err)
+	}
+}
+
+func TestHTTPBackendConflict(t *testing.T) {
+	server := newFakeHTTPStore()
+	defer server.Close()
+	backend, err := newHTTPBackend(server.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := tempStore(t)
+	defer os.RemoveAll(root)
+	if err := backend.push(root, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Two operations pull the machine, and the second one to push it
+	// must not overwrite the changes of the first one.
+	first, second := tempDir(t), tempDir(t)
+	defer os.RemoveAll(first)
+	defer os.RemoveAll(second)
+	for _, dir := range []string{first, second} {
+		if err := backend.pull(dir, "test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := backend.push(first, "test"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := backend.push(second, "test"); err == nil || !strings.Contains(err.Error(), "changed in the store by another operation") {
+		t.Errorf("expected a conflict error, got %v", err)
+	}
+
+	// A machine created concurrently is not overwritten either.
+	created := tempStore(t)
+	defer os.RemoveAll(created)
+	if err := backend.push(created, "test"); err == nil {
+		t.Error("expected a conflict error pushing a new machine over an existing one")
+	}
+}
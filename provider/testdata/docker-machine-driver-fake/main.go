@@ -0,0 +1,72 @@
+// docker-machine-driver-fake is a driver plugin used by the tests of external
+// drivers. It only reports create flags of each type, and records their values.
+package main
+
+import (
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/docker/machine/libmachine/drivers/plugin"
+	"github.com/docker/machine/libmachine/mcnflag"
+	"github.com/docker/machine/libmachine/state"
+)
+
+type Driver struct {
+	*drivers.BaseDriver
+	Region  string
+	Size    int
+	Private bool
+	Tags    []string
+}
+
+func (d *Driver) DriverName() string {
+	return "fake"
+}
+
+func (d *Driver) GetCreateFlags() []mcnflag.Flag {
+	return []mcnflag.Flag{
+		mcnflag.StringFlag{
+			Name:  "fake-region",
+			Usage: "Region of the machine",
+			Value: "us-east",
+		},
+		mcnflag.IntFlag{
+			Name:  "fake-size",
+			Usage: "Size of the machine",
+			Value: 1,
+		},
+		mcnflag.BoolFlag{
+			Name:  "fake-private",
+			Usage: "Only use a private address",
+		},
+		mcnflag.StringSliceFlag{
+			Name:  "fake-tag",
+			Usage: "Tags of the machine",
+			Value: []string{},
+		},
+	}
+}
+
+func (d *Driver) SetConfigFromFlags(opts drivers.DriverOptions) error {
+	d.Region = opts.String("fake-region")
+	d.Size = opts.Int("fake-size")
+	d.Private = opts.Bool("fake-private")
+	d.Tags = opts.StringSlice("fake-tag")
+	return nil
+}
+
+func (d *Driver) Create() error                   { return nil }
+func (d *Driver) GetSSHHostname() (string, error) { return d.GetIP() }
+func (d *Driver) GetURL() (string, error)         { return "tcp://" + d.IPAddress + ":2376", nil }
+func (d *Driver) GetState() (state.State, error)  { return state.Running, nil }
+func (d *Driver) Kill() error                     { return nil }
+func (d *Driver) Remove() error                   { return nil }
+func (d *Driver) Restart() error                  { return nil }
+func (d *Driver) Start() error                    { return nil }
+func (d *Driver) Stop() error                     { return nil }
+
+func main() {
+	plugin.RegisterDriver(&Driver{
+		BaseDriver: &drivers.BaseDriver{
+			IPAddress: "127.0.0.1",
+		},
+	})
+}
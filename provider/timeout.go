@@ -0,0 +1,202 @@
+package provider
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff"
+
+	"github.com/docker/machine/libmachine/host"
+)
+
+// timedOperation enforces the timeout of a resource operation over the calls
+// to libmachine, the driver and the provisioner, which have no timeout of
+// their own, and keeps track of the phase the operation is in.
+type timedOperation struct {
+	action   string
+	timeout  time.Duration
+	deadline time.Time
+	phase    string
+	// abandoned holds the calls still running past the deadline.
+	abandoned []*call
+	// keys makes the calls with the private keys of the machine, see
+	// useKeys.
+	keys func(func() error) error
+	// parent is the operation a child operation is part of, see child.
+	parent *timedOperation
+	mutex  sync.Mutex
+}
+
+func newTimedOperation(action string, timeout time.Duration) *timedOperation {
+	return &timedOperation{
+		action:   action,
+		timeout:  timeout,
+		deadline: time.Now().Add(timeout),
+	}
+}
+
+// child returns an operation bounded by timeout within the deadline of o, for
+// a step of o with a timeout of its own. Its phases and abandoned calls are
+// those of o as well, and its calls use the keys of o.
+func (o *timedOperation) child(action string, timeout time.Duration) *timedOperation {
+	c := newTimedOperation(action, timeout)
+	if o.deadline.Before(c.deadline) {
+		c.timeout = o.remaining()
+		c.deadline = o.deadline
+	}
+	c.keys = o.keys
+	c.parent = o
+	return c
+}
+
+// setPhase records the phase the operation enters.
+func (o *timedOperation) setPhase(phase string) {
+	o.mutex.Lock()
+	o.phase = phase
+	o.mutex.Unlock()
+	if o.parent != nil {
+		o.parent.setPhase(phase)
+	}
+}
+
+func (o *timedOperation) currentPhase() string {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return o.phase
+}
+
+// useKeys makes the calls of the operation with the private keys of h, which
+// an encrypted store only decrypts for the duration of each call.
+func (o *timedOperation) useKeys(store *machineStore, h *host.Host) {
+	o.keys = func(fn func() error) error {
+		return store.withKeys(h, fn)
+	}
+}
+
+// remaining returns the time left before the deadline of the operation.
+func (o *timedOperation) remaining() time.Duration {
+	return time.Until(o.deadline)
+}
+
+// backoff returns an exponential backoff retrying until the deadline of the
+// operation. A zero MaxElapsedTime would retry forever: once the deadline has
+// passed, the backoff allows a single attempt.
+func (o *timedOperation) backoff() *backoff.ExponentialBackOff {
+	b := backoff.NewExponentialBackOff()
+	b.MaxElapsedTime = o.remaining()
+	if b.MaxElapsedTime <= 0 {
+		b.MaxElapsedTime = time.Nanosecond
+	}
+	return b
+}
+
+// timeoutError returns the error of the operation timing out in its current
+// phase.
+func (o *timedOperation) timeoutError() error {
+	return &timeoutError{
+		action:  o.action,
+		phase:   o.currentPhase(),
+		timeout: o.timeout,
+	}
+}
+
+// call is a function running in the background for an operation.
+type call struct {
+	done chan struct{}
+	err  error
+}
+
+// wait waits for up to timeout for c to return, and tells whether it did.
+func (c *call) wait(timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-c.done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// start calls fn in the background, in the given phase.
+func (o *timedOperation) start(phase string, fn func() error) *call {
+	o.setPhase(phase)
+	c := &call{done: make(chan struct{})}
+	go func() {
+		defer close(c.done)
+		if o.keys != nil {
+			c.err = o.keys(fn)
+		} else {
+			c.err = fn()
+		}
+	}()
+	return c
+}
+
+// finish waits for c to return until the deadline of the operation. Since
+// calls cannot be interrupted, c is abandoned if the deadline passes first:
+// it keeps running in the background, and must therefore leave the resource
+// data alone, while the resources it uses are kept by afterAbandoned.
+func (o *timedOperation) finish(c *call) error {
+	if c.wait(o.remaining()) {
+		return c.err
+	}
+	o.abandon(c)
+	return o.timeoutError()
+}
+
+// abandon records c as abandoned by the operation, and by its parent.
+func (o *timedOperation) abandon(c *call) {
+	o.mutex.Lock()
+	o.abandoned = append(o.abandoned, c)
+	o.mutex.Unlock()
+	if o.parent != nil {
+		o.parent.abandon(c)
+	}
+}
+
+// run calls fn in the given phase, and returns a timeoutError if the deadline
+// of the operation passes before it returns. Once the deadline has passed, fn
+// is not called at all.
+func (o *timedOperation) run(phase string, fn func() error) error {
+	if o.remaining() <= 0 {
+		o.setPhase(phase)
+		return o.timeoutError()
+	}
+	return o.finish(o.start(phase, fn))
+}
+
+// afterAbandoned calls cleanup in the background once the calls abandoned by
+// the operation have returned, so that they keep the store, locks and slots
+// they use until then, and tells whether there were any. Otherwise, cleanup
+// is not called. A nil operation, as used by reads, has no calls.
+func (o *timedOperation) afterAbandoned(cleanup func()) bool {
+	if o == nil {
+		return false
+	}
+	o.mutex.Lock()
+	abandoned := o.abandoned
+	o.mutex.Unlock()
+	if len(abandoned) == 0 {
+		return false
+	}
+	go func() {
+		for _, c := range abandoned {
+			<-c.done
+		}
+		cleanup()
+	}()
+	return true
+}
+
+// timeoutError is returned when an operation does not complete in time.
+type timeoutError struct {
+	action  string
+	phase   string
+	timeout time.Duration
+}
+
+func (e *timeoutError) Error() string {
+	return fmt.Sprintf("Timeout after %s while %s, during %s", e.timeout, e.action, e.phase)
+}
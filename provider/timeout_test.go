@@ -0,0 +1,44 @@
+package provider
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimedOperationBackoff(t *testing.T) {
+	op := newTimedOperation("testing", time.Minute)
+	if b := op.backoff(); b.MaxElapsedTime <= 0 || b.MaxElapsedTime > time.Minute {
+		t.Errorf("expected the backoff to stop at the deadline, got %s", b.MaxElapsedTime)
+	}
+
+	// Past the deadline, the backoff must not retry forever.
+	expired := newTimedOperation("testing", -time.Second)
+	if b := expired.backoff(); b.MaxElapsedTime != time.Nanosecond {
+		t.Errorf("expected a single attempt past the deadline, got %s", b.MaxElapsedTime)
+	}
+}
+
+func TestTimedOperationChild(t *testing.T) {
+	op := newTimedOperation("testing", 50*time.Millisecond)
+	child := op.child("waiting", time.Hour)
+	if child.deadline.After(op.deadline) {
+		t.Errorf("expected the child to end by the deadline of its parent")
+	}
+
+	// Calls abandoned by the child are kept track of by the parent.
+	done := make(chan struct{})
+	defer close(done)
+	err := child.run("hanging", func() error {
+		<-done
+		return nil
+	})
+	if _, ok := err.(*timeoutError); !ok {
+		t.Fatalf("expected a timeout error, got %v", err)
+	}
+	if len(op.abandoned) != 1 {
+		t.Errorf("expected the parent to have 1 abandoned call, got %d", len(op.abandoned))
+	}
+	if phase := op.currentPhase(); phase != "hanging" {
+		t.Errorf("expected the parent to be in the phase of the child, got %q", phase)
+	}
+}
@@ -1,5 +1,12 @@
 package provider
 
+import (
+	"fmt"
+	"time"
+
+	"github.com/docker/machine/libmachine/mcnflag"
+)
+
 func ss2is(s []string) []interface{} {
 	ret := make([]interface{}, len(s))
 	for i := range s {
@@ -15,3 +22,26 @@ func is2ss(s []interface{}) []string {
 	}
 	return ret
 }
+
+// derefFlag returns flags received from a driver plugin, which are decoded as
+// pointers, in the same form as the ones of the embedded drivers.
+func derefFlag(flag mcnflag.Flag) mcnflag.Flag {
+	switch f := flag.(type) {
+	case *mcnflag.StringFlag:
+		return *f
+	case *mcnflag.StringSliceFlag:
+		return *f
+	case *mcnflag.IntFlag:
+		return *f
+	case *mcnflag.BoolFlag:
+		return *f
+	}
+	return flag
+}
+
+func validateDuration(v interface{}, k string) ([]string, []error) {
+	if _, err := time.ParseDuration(v.(string)); err != nil {
+		return nil, []error{fmt.Errorf("%q must be a duration (e.g. \"720h\"): %s", k, err)}
+	}
+	return nil, nil
+}